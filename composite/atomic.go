@@ -0,0 +1,75 @@
+package composite
+
+////////////////////////////////////////////////////////////////////////////////
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+////////////////////////////////////////////////////////////////////////////////
+
+// AtomicFile is written to a temporary file next to its final path, which is
+// only renamed into place once the file is committed.  A failed write never
+// leaves a truncated file behind, or replaces a good one.
+type AtomicFile struct {
+	*os.File
+	path string
+}
+
+// CreateAtomic creates the temporary file for `path`.
+func CreateAtomic(path string) (*AtomicFile, error) {
+	fd, err := tempFile(path)
+	if err != nil {
+		return nil, err
+	}
+	return &AtomicFile{File: fd, path: path}, nil
+}
+
+// Commit closes the file and renames it into place.  The temporary file is
+// removed if that fails.
+func (f *AtomicFile) Commit() error {
+	err := f.File.Close()
+	if err == nil {
+		err = os.Rename(f.File.Name(), f.path)
+	}
+	if err != nil {
+		os.Remove(f.File.Name())
+	}
+	return err
+}
+
+// Abort closes and removes the temporary file, leaving the file at the final
+// path (if any) untouched.
+func (f *AtomicFile) Abort() {
+	f.File.Close()
+	os.Remove(f.File.Name())
+}
+
+// tempFile creates a uniquely named, hidden file in the same directory as
+// `path`, so that it can be renamed over it.  The extension is kept for tools
+// that infer the format of a file from it.  Temporary files left behind by a
+// run that was killed never collide with it.
+func tempFile(path string) (*os.File, error) {
+	dir, base := filepath.Split(path)
+	if len(dir) == 0 {
+		dir = "."
+	}
+	ext := filepath.Ext(base)
+	fd, err := ioutil.TempFile(dir, "."+strings.TrimSuffix(base, ext)+".*"+ext)
+	if err != nil {
+		return nil, err
+	}
+	// TempFile creates the file readable only by its owner, but it replaces
+	// an output which would have been created with the usual permissions.
+	if err := fd.Chmod(0644); err != nil {
+		fd.Close()
+		os.Remove(fd.Name())
+		return nil, err
+	}
+	return fd, nil
+}
+
+////////////////////////////////////////////////////////////////////////////////
@@ -0,0 +1,181 @@
+package composite
+
+////////////////////////////////////////////////////////////////////////////////
+
+import (
+	"fmt"
+	"image"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/sabhiram/imagenie/composite/cmyk"
+	"github.com/sabhiram/imagenie/composite/filter"
+)
+
+////////////////////////////////////////////////////////////////////////////////
+
+// Job is a single image to be built by a backend: the renderables are
+// composited onto the background, and the result is written to `Path`.
+type Job struct {
+	Background string
+	Filters    []filter.Filter // applied to the background
+	Items      []Renderable
+	Path       string
+	Format     string          // output format (ex: "png")
+	ColorSpace string          // "rgba" or "cmyk"
+	Dpi        float64         // pixels per inch, for formats that record it
+	CMYK       *cmyk.Converter // converts images in the cmyk colorspace
+}
+
+// Capabilities describe what a backend is able to produce.
+type Capabilities struct {
+	Formats     []string    // output formats
+	ColorSpaces []string    // output colorspaces
+	CMYKFormats []string    // output formats that can be written in cmyk
+	BlendModes  []BlendMode // supported blend modes
+}
+
+func hasString(list []string, s string) bool {
+	for _, v := range list {
+		if strings.EqualFold(v, s) {
+			return true
+		}
+	}
+	return false
+}
+
+// HasFormat returns true if the backend writes files of the format.
+func (c *Capabilities) HasFormat(offmt string) bool {
+	return hasString(c.Formats, offmt)
+}
+
+// HasColorSpace returns true if the backend writes files of the format in the
+// colorspace.
+func (c *Capabilities) HasColorSpace(cs, offmt string) bool {
+	if !hasString(c.ColorSpaces, cs) {
+		return false
+	}
+	return !strings.EqualFold(cs, "cmyk") || hasString(c.CMYKFormats, offmt)
+}
+
+// HasBlendMode returns true if the backend composites with the blend mode.
+func (c *Capabilities) HasBlendMode(m BlendMode) bool {
+	for _, v := range c.BlendModes {
+		if v == m {
+			return true
+		}
+	}
+	return false
+}
+
+// Backend builds images from jobs.
+type Backend interface {
+	Name() string
+	Capabilities() *Capabilities
+	Build(job *Job) error
+}
+
+////////////////////////////////////////////////////////////////////////////////
+
+// BackendOptions configure the backends as they are created.  Each backend
+// uses the options that apply to it.
+type BackendOptions struct {
+	MagickBins string // directory of the imagemagick binaries, or "" for $PATH
+}
+
+// BackendFactory creates a backend with the specified options.
+type BackendFactory func(opts BackendOptions) (Backend, error)
+
+var (
+	backendsMu sync.Mutex
+	backends   = map[string]BackendFactory{}
+)
+
+// RegisterBackend makes a backend available by name.  Registering the same
+// name twice panics.
+func RegisterBackend(name string, f BackendFactory) {
+	backendsMu.Lock()
+	defer backendsMu.Unlock()
+	if _, ok := backends[name]; ok {
+		panic("composite: backend registered twice: " + name)
+	}
+	backends[name] = f
+}
+
+// NewBackend creates the backend registered as `name`.
+func NewBackend(name string, opts BackendOptions) (Backend, error) {
+	backendsMu.Lock()
+	f, ok := backends[strings.ToLower(name)]
+	backendsMu.Unlock()
+	if !ok {
+		return nil, fmt.Errorf("%s is not a valid backend (%s)", name, strings.Join(Backends(), ", "))
+	}
+	return f(opts)
+}
+
+// Backends returns the names of the registered backends.
+func Backends() []string {
+	backendsMu.Lock()
+	defer backendsMu.Unlock()
+	names := []string{}
+	for name := range backends {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+func init() {
+	RegisterBackend("native", func(BackendOptions) (Backend, error) {
+		return nativeBackend{}, nil
+	})
+	RegisterBackend("imagemagick", newMagickBackend)
+}
+
+////////////////////////////////////////////////////////////////////////////////
+
+// nativeBackend composites and encodes images in Go.
+type nativeBackend struct{}
+
+var nativeCapabilities = &Capabilities{
+	Formats:     []string{"jpeg", "jpg", "png", "tiff", "tif", "pdf"},
+	ColorSpaces: []string{"rgba", "cmyk"},
+	CMYKFormats: []string{"jpeg", "jpg", "tiff", "tif"},
+	BlendModes: []BlendMode{
+		BlendOver, BlendAtop, BlendIn, BlendOut, BlendXor,
+		BlendMultiply, BlendScreen, BlendOverlay, BlendDarken, BlendLighten,
+	},
+}
+
+func (nativeBackend) Name() string {
+	return "native"
+}
+
+func (nativeBackend) Capabilities() *Capabilities {
+	return nativeCapabilities
+}
+
+func (nativeBackend) Build(job *Job) error {
+	bg, err := LoadBackground(job.Background, job.Filters)
+	if err != nil {
+		return err
+	}
+	img, err := RenderOnto(bg, job.Items)
+	if err != nil {
+		return err
+	}
+	return WriteImage(PrintImage(img, job.Format, job.ColorSpace, job.CMYK), job.Path, job.Format, job.Dpi)
+}
+
+// PrintImage returns the image to write to a file of the specified format,
+// which is converted to CMYK by `conv` in the cmyk colorspace if the native
+// backend writes the format in CMYK.
+func PrintImage(img image.Image, offmt, cs string, conv *cmyk.Converter) image.Image {
+	if conv == nil || !strings.EqualFold(cs, "cmyk") || !nativeCapabilities.HasColorSpace(cs, offmt) {
+		return img
+	}
+	return conv.Convert(img)
+}
+
+////////////////////////////////////////////////////////////////////////////////
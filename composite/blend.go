@@ -0,0 +1,244 @@
+package composite
+
+////////////////////////////////////////////////////////////////////////////////
+
+import (
+	"fmt"
+	"image"
+	"image/draw"
+	"math"
+	"strings"
+)
+
+////////////////////////////////////////////////////////////////////////////////
+
+// BlendMode is the operator used to composite an overlay onto the image built
+// so far.  The Porter-Duff operators (over, atop, in, out, xor) and the
+// separable blend modes (multiply, screen, overlay, darken, lighten) follow
+// the W3C "Compositing and Blending" definitions.
+type BlendMode int
+
+const (
+	BlendOver BlendMode = iota
+	BlendAtop
+	BlendIn
+	BlendOut
+	BlendXor
+	BlendMultiply
+	BlendScreen
+	BlendOverlay
+	BlendDarken
+	BlendLighten
+)
+
+var blendModeNames = map[BlendMode]string{
+	BlendOver:     "over",
+	BlendAtop:     "atop",
+	BlendIn:       "in",
+	BlendOut:      "out",
+	BlendXor:      "xor",
+	BlendMultiply: "multiply",
+	BlendScreen:   "screen",
+	BlendOverlay:  "overlay",
+	BlendDarken:   "darken",
+	BlendLighten:  "lighten",
+}
+
+func (m BlendMode) String() string {
+	if s, ok := blendModeNames[m]; ok {
+		return s
+	}
+	return fmt.Sprintf("BlendMode(%d)", int(m))
+}
+
+// ParseBlendMode returns the blend mode for the specified name.  An empty name
+// maps to the default "over" operator.
+func ParseBlendMode(s string) (BlendMode, error) {
+	s = strings.ToLower(s)
+	if len(s) == 0 {
+		return BlendOver, nil
+	}
+	for m, name := range blendModeNames {
+		if name == s {
+			return m, nil
+		}
+	}
+	return BlendOver, fmt.Errorf("%s is not a valid blend mode", s)
+}
+
+////////////////////////////////////////////////////////////////////////////////
+
+// Blender is implemented by renderables that specify how they are to be
+// composited.  Renderables that do not implement it are drawn "over" the
+// image at full opacity.
+type Blender interface {
+	Blend() (BlendMode, float64)
+}
+
+// Layer decorates a renderable with its placement on the background, and the
+// blend mode and opacity to composite it with.
+type Layer struct {
+	Renderable
+	place   Placement
+	mode    BlendMode
+	opacity float64
+}
+
+func NewLayer(r Renderable, place Placement, mode BlendMode, opacity float64) *Layer {
+	return &Layer{
+		Renderable: r,
+		place:      place,
+		mode:       mode,
+		opacity:    opacity,
+	}
+}
+
+func (l *Layer) Blend() (BlendMode, float64) {
+	return l.mode, l.opacity
+}
+
+func (l *Layer) Place(bg image.Rectangle, size image.Point) image.Point {
+	return l.place.Resolve(bg, size)
+}
+
+// Report forwards to the decorated renderable, if it is a Reporter.
+func (l *Layer) Report() string {
+	if r, ok := l.Renderable.(Reporter); ok {
+		return r.Report()
+	}
+	return ""
+}
+
+func blendOf(r Renderable) (BlendMode, float64) {
+	if b, ok := r.(Blender); ok {
+		return b.Blend()
+	}
+	return BlendOver, 1.0
+}
+
+// placeOf returns the top-left corner of the rendered image on the background.
+func placeOf(r Renderable, bg image.Rectangle, img image.Image, xoff, yoff int) image.Point {
+	if p, ok := r.(Placer); ok {
+		return p.Place(bg, img.Bounds().Size())
+	}
+	return bg.Min.Add(image.Pt(xoff, yoff))
+}
+
+////////////////////////////////////////////////////////////////////////////////
+
+// Composite draws `src` onto `dst` with its top-left corner at `pt` using the
+// specified blend mode and opacity.  Only the pixels of `dst` covered by `src`
+// are modified.
+func Composite(dst *image.RGBA, src image.Image, pt image.Point, mode BlendMode, opacity float64) {
+	sb := src.Bounds()
+	r := image.Rectangle{pt, pt.Add(sb.Size())}.Intersect(dst.Bounds())
+	if r.Empty() {
+		return
+	}
+	sp := sb.Min.Add(r.Min.Sub(pt))
+	opacity = math.Max(0, math.Min(1, opacity))
+
+	// The common case is handled by `image/draw` directly.
+	if mode == BlendOver {
+		if opacity >= 1 {
+			draw.Draw(dst, r, src, sp, draw.Over)
+		} else {
+			mask := image.NewUniform(alphaColor(opacity))
+			draw.DrawMask(dst, r, src, sp, mask, image.ZP, draw.Over)
+		}
+		return
+	}
+
+	// Convert the source into premultiplied RGBA so that each pixel can be
+	// blended without repeated color model conversions.
+	s := image.NewRGBA(image.Rectangle{image.ZP, r.Size()})
+	draw.Draw(s, s.Bounds(), src, sp, draw.Src)
+
+	w, h := r.Dx(), r.Dy()
+	for y := 0; y < h; y++ {
+		si := s.PixOffset(0, y)
+		di := dst.PixOffset(r.Min.X, r.Min.Y+y)
+		for x := 0; x < w; x++ {
+			sa := float64(s.Pix[si+3]) / 255 * opacity
+			da := float64(dst.Pix[di+3]) / 255
+
+			var out [4]float64
+			out[3] = blendAlpha(mode, sa, da)
+			for c := 0; c < 3; c++ {
+				sc := float64(s.Pix[si+c]) / 255 * opacity
+				dc := float64(dst.Pix[di+c]) / 255
+				out[c] = blendChannel(mode, sc, sa, dc, da)
+			}
+			for c := 0; c < 4; c++ {
+				v := math.Min(out[c], out[3])
+				dst.Pix[di+c] = uint8(math.Max(0, math.Min(255, v*255+0.5)))
+			}
+			si += 4
+			di += 4
+		}
+	}
+}
+
+// blendAlpha returns the resulting alpha for the source and destination alphas.
+func blendAlpha(mode BlendMode, sa, da float64) float64 {
+	switch mode {
+	case BlendAtop:
+		return da
+	case BlendIn:
+		return sa * da
+	case BlendOut:
+		return sa * (1 - da)
+	case BlendXor:
+		return sa + da - 2*sa*da
+	}
+	return sa + da*(1-sa)
+}
+
+// blendChannel returns the resulting premultiplied channel value for the
+// premultiplied source and destination channels.
+func blendChannel(mode BlendMode, sc, sa, dc, da float64) float64 {
+	switch mode {
+	case BlendAtop:
+		return sc*da + dc*(1-sa)
+	case BlendIn:
+		return sc * da
+	case BlendOut:
+		return sc * (1 - da)
+	case BlendXor:
+		return sc*(1-da) + dc*(1-sa)
+	case BlendMultiply, BlendScreen, BlendOverlay, BlendDarken, BlendLighten:
+		// Separable blend modes work on the non-premultiplied colors, and are
+		// then composited "over" the destination.
+		var cs, cb float64
+		if sa > 0 {
+			cs = sc / sa
+		}
+		if da > 0 {
+			cb = dc / da
+		}
+		return sc*(1-da) + dc*(1-sa) + sa*da*blendFunc(mode, cb, cs)
+	}
+	return sc + dc*(1-sa)
+}
+
+// blendFunc is the separable blend function B(Cb, Cs) for the given mode.
+func blendFunc(mode BlendMode, cb, cs float64) float64 {
+	switch mode {
+	case BlendMultiply:
+		return cb * cs
+	case BlendScreen:
+		return cb + cs - cb*cs
+	case BlendOverlay:
+		if cb <= 0.5 {
+			return 2 * cs * cb
+		}
+		return 1 - 2*(1-cs)*(1-cb)
+	case BlendDarken:
+		return math.Min(cb, cs)
+	case BlendLighten:
+		return math.Max(cb, cs)
+	}
+	return cs
+}
+
+////////////////////////////////////////////////////////////////////////////////
@@ -0,0 +1,188 @@
+package composite
+
+////////////////////////////////////////////////////////////////////////////////
+
+import (
+	"image"
+	"image/color"
+	"math"
+	"testing"
+)
+
+////////////////////////////////////////////////////////////////////////////////
+
+func TestParseBlendMode(t *testing.T) {
+	for m, name := range blendModeNames {
+		if got, err := ParseBlendMode(name); err != nil || got != m {
+			t.Errorf("%s: expected %d, got %d, %v", name, m, got, err)
+		}
+	}
+	if m, err := ParseBlendMode(""); err != nil || m != BlendOver {
+		t.Errorf("expected over by default, got %s, %v", m, err)
+	}
+	if m, err := ParseBlendMode("Multiply"); err != nil || m != BlendMultiply {
+		t.Errorf("expected multiply, got %s, %v", m, err)
+	}
+	if _, err := ParseBlendMode("dodge"); err == nil {
+		t.Error("expected an error for dodge")
+	}
+}
+
+// A half transparent source (color 0.5) onto a half transparent destination
+// (color 0.8), with premultiplied channels.
+func TestBlendMath(t *testing.T) {
+	const sa, sc, da, dc = 0.5, 0.25, 0.5, 0.4
+	for _, c := range []struct {
+		mode          BlendMode
+		alpha, result float64
+	}{
+		{BlendOver, 0.75, 0.45},
+		{BlendAtop, 0.5, 0.325},
+		{BlendIn, 0.25, 0.125},
+		{BlendOut, 0.25, 0.125},
+		{BlendXor, 0.5, 0.325},
+		{BlendMultiply, 0.75, 0.425},
+		{BlendScreen, 0.75, 0.55},
+		{BlendOverlay, 0.75, 0.525},
+		{BlendDarken, 0.75, 0.45},
+		{BlendLighten, 0.75, 0.525},
+	} {
+		if a := blendAlpha(c.mode, sa, da); math.Abs(a-c.alpha) > 1e-9 {
+			t.Errorf("%s: expected alpha %v, got %v", c.mode, c.alpha, a)
+		}
+		if r := blendChannel(c.mode, sc, sa, dc, da); math.Abs(r-c.result) > 1e-9 {
+			t.Errorf("%s: expected channel %v, got %v", c.mode, c.result, r)
+		}
+	}
+}
+
+func TestBlendFunc(t *testing.T) {
+	for _, c := range []struct {
+		mode             BlendMode
+		cb, cs, expected float64
+	}{
+		{BlendMultiply, 0.5, 0.4, 0.2},
+		{BlendScreen, 0.5, 0.4, 0.7},
+		{BlendOverlay, 0.5, 0.4, 0.4},
+		{BlendOverlay, 0.25, 0.4, 0.2},
+		{BlendOverlay, 0.75, 0.4, 0.7},
+		{BlendDarken, 0.5, 0.4, 0.4},
+		{BlendLighten, 0.5, 0.4, 0.5},
+		{BlendOver, 0.5, 0.4, 0.4},
+	} {
+		if got := blendFunc(c.mode, c.cb, c.cs); math.Abs(got-c.expected) > 1e-9 {
+			t.Errorf("%s(%v, %v): expected %v, got %v", c.mode, c.cb, c.cs, c.expected, got)
+		}
+	}
+}
+
+// Each mode composites an opaque pixel onto the center of an opaque 3x3
+// image, leaving the pixels around it untouched.
+func TestComposite(t *testing.T) {
+	dc := color.RGBA{204, 102, 51, 255} // 0.8, 0.4, 0.2
+	sc := color.RGBA{51, 153, 255, 255} // 0.2, 0.6, 1.0
+	for _, c := range []struct {
+		mode     BlendMode
+		opacity  float64
+		expected color.RGBA
+	}{
+		{BlendOver, 1, sc},
+		{BlendOver, 0.5, color.RGBA{128, 128, 153, 255}},
+		{BlendOver, 0, dc},
+		{BlendAtop, 1, sc},
+		{BlendIn, 1, sc},
+		{BlendOut, 1, color.RGBA{0, 0, 0, 0}},
+		{BlendXor, 1, color.RGBA{0, 0, 0, 0}},
+		{BlendXor, 0.5, color.RGBA{102, 51, 26, 128}},
+		{BlendMultiply, 1, color.RGBA{41, 61, 51, 255}},
+		{BlendMultiply, 0.5, color.RGBA{122, 82, 51, 255}},
+		{BlendScreen, 1, color.RGBA{214, 194, 255, 255}},
+		{BlendOverlay, 1, color.RGBA{173, 122, 102, 255}},
+		{BlendDarken, 1, color.RGBA{51, 102, 51, 255}},
+		{BlendLighten, 1, color.RGBA{204, 153, 255, 255}},
+	} {
+		dst := image.NewRGBA(image.Rect(0, 0, 3, 3))
+		for y := 0; y < 3; y++ {
+			for x := 0; x < 3; x++ {
+				dst.SetRGBA(x, y, dc)
+			}
+		}
+		src := image.NewRGBA(image.Rect(4, 4, 5, 5))
+		src.SetRGBA(4, 4, sc)
+		Composite(dst, src, image.Pt(1, 1), c.mode, c.opacity)
+
+		for y := 0; y < 3; y++ {
+			for x := 0; x < 3; x++ {
+				expected := dc
+				if x == 1 && y == 1 {
+					expected = c.expected
+				}
+				if got := dst.RGBAAt(x, y); !closeRGBA(got, expected, 1) {
+					t.Errorf("%s at %v: expected %v at (%d, %d), got %v", c.mode, c.opacity, expected, x, y, got)
+				}
+			}
+		}
+	}
+}
+
+// Composite clips the source to the destination.
+func TestCompositeClip(t *testing.T) {
+	dst := image.NewRGBA(image.Rect(0, 0, 2, 2))
+	src := image.NewRGBA(image.Rect(0, 0, 2, 2))
+	for i := range src.Pix {
+		src.Pix[i] = 255
+	}
+	Composite(dst, src, image.Pt(1, -1), BlendMultiply, 1)
+	for y := 0; y < 2; y++ {
+		for x := 0; x < 2; x++ {
+			expected := color.RGBA{}
+			if x == 1 && y == 0 {
+				expected = color.RGBA{255, 255, 255, 255}
+			}
+			if got := dst.RGBAAt(x, y); got != expected {
+				t.Errorf("expected %v at (%d, %d), got %v", expected, x, y, got)
+			}
+		}
+	}
+}
+
+func TestApplyOpacity(t *testing.T) {
+	src := image.NewNRGBA(image.Rect(5, 5, 7, 6))
+	src.SetNRGBA(5, 5, color.NRGBA{255, 0, 0, 255})
+	src.SetNRGBA(6, 5, color.NRGBA{0, 0, 255, 128})
+	for _, c := range []struct {
+		opacity  float64
+		expected [2]color.RGBA
+	}{
+		{1, [2]color.RGBA{{255, 0, 0, 255}, {0, 0, 128, 128}}},
+		{0.5, [2]color.RGBA{{128, 0, 0, 128}, {0, 0, 64, 64}}},
+		{0.25, [2]color.RGBA{{64, 0, 0, 64}, {0, 0, 32, 32}}},
+		{0, [2]color.RGBA{{}, {}}},
+	} {
+		img := applyOpacity(src, c.opacity)
+		if b := img.Bounds(); b != image.Rect(0, 0, 2, 1) {
+			t.Fatalf("expected the image at the origin, got %v", b)
+		}
+		for x, expected := range c.expected {
+			got := color.RGBAModel.Convert(img.At(x, 0)).(color.RGBA)
+			if !closeRGBA(got, expected, 1) {
+				t.Errorf("%v: expected %v at %d, got %v", c.opacity, expected, x, got)
+			}
+		}
+	}
+}
+
+// closeRGBA returns true if no channel of the colors differs by more than
+// `tolerance`.
+func closeRGBA(a, b color.RGBA, tolerance int) bool {
+	for _, d := range []int{
+		int(a.R) - int(b.R), int(a.G) - int(b.G), int(a.B) - int(b.B), int(a.A) - int(b.A),
+	} {
+		if d < -tolerance || d > tolerance {
+			return false
+		}
+	}
+	return true
+}
+
+////////////////////////////////////////////////////////////////////////////////
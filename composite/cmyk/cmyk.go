@@ -0,0 +1,129 @@
+package cmyk
+
+////////////////////////////////////////////////////////////////////////////////
+/*
+
+Package cmyk converts composited images to CMYK for print, and writes them as
+CMYK JPEG and TIFF files without relying on any external tools.
+
+The conversion is a device (naive) conversion with configurable black
+generation and total ink limit.  An ICC profile can be attached to the image,
+which is embedded in the files to tag the intended output condition, but it
+is not used for the conversion itself.
+
+*/
+////////////////////////////////////////////////////////////////////////////////
+
+import (
+	"fmt"
+	"image"
+	"io/ioutil"
+	"math"
+)
+
+////////////////////////////////////////////////////////////////////////////////
+
+// Image is a CMYK image along with the ICC profile (if any) to embed in the
+// files that it is written to.
+type Image struct {
+	*image.CMYK
+	Profile []byte
+}
+
+////////////////////////////////////////////////////////////////////////////////
+
+// Converter converts RGB images to CMYK.
+type Converter struct {
+	// Black is the fraction (0 to 1) of the gray component of each color
+	// that is replaced by black ink.  Replacing all of it is the same as
+	// `color.RGBToCMYK`, and replacing none of it prints grays with cyan,
+	// magenta and yellow only.
+	Black float64
+
+	// InkLimit is the maximum total ink coverage in percent (up to 400).
+	// Cyan, magenta and yellow are reduced in proportion to stay within it.
+	// The zero value does not limit the coverage.
+	InkLimit float64
+
+	// Profile is the ICC profile to embed in the converted images.
+	Profile []byte
+}
+
+// NewConverter returns a converter with the specified black generation and
+// ink limit, which embeds the ICC profile at `profile` (if specified) in the
+// converted images.  The colors are not converted through the profile.
+func NewConverter(black, inkLimit float64, profile string) (*Converter, error) {
+	if black < 0 || black > 1 {
+		return nil, fmt.Errorf("cmyk: black must be between 0 and 1, got %v", black)
+	}
+	if inkLimit < 0 || inkLimit > 400 {
+		return nil, fmt.Errorf("cmyk: ink limit must be between 0 and 400%%, got %v", inkLimit)
+	}
+
+	c := &Converter{Black: black, InkLimit: inkLimit}
+	if len(profile) > 0 {
+		data, err := ioutil.ReadFile(profile)
+		if err != nil {
+			return nil, fmt.Errorf("cmyk: unable to read icc profile: %s", err.Error())
+		}
+		if len(data) < 128 || string(data[36:40]) != "acsp" {
+			return nil, fmt.Errorf("cmyk: %s is not an icc profile", profile)
+		}
+		c.Profile = data
+	}
+	return c, nil
+}
+
+// Convert returns a CMYK copy of the image.  Transparent pixels are treated as
+// white (no ink), as that is what they are printed as.
+func (c *Converter) Convert(img image.Image) *Image {
+	b := img.Bounds()
+	out := image.NewCMYK(b)
+	black := c.Black
+	limit := c.InkLimit / 100
+	if limit == 0 {
+		limit = 4
+	}
+
+	for y := b.Min.Y; y < b.Max.Y; y++ {
+		i := out.PixOffset(b.Min.X, y)
+		for x := b.Min.X; x < b.Max.X; x, i = x+1, i+4 {
+			r, g, bb, a := img.At(x, y).RGBA()
+
+			// Composite onto white, and convert to the ink of each primary.
+			cc := 1 - float64(r+0xffff-a)/0xffff
+			mm := 1 - float64(g+0xffff-a)/0xffff
+			yy := 1 - float64(bb+0xffff-a)/0xffff
+
+			// Replace the gray component with black, so that black ink
+			// printed over the remaining inks reproduces the color.
+			k := black * math.Min(cc, math.Min(mm, yy))
+			if k < 1 {
+				cc = (cc - k) / (1 - k)
+				mm = (mm - k) / (1 - k)
+				yy = (yy - k) / (1 - k)
+			} else {
+				cc, mm, yy = 0, 0, 0
+			}
+
+			if total := cc + mm + yy + k; total > limit {
+				if cmy := cc + mm + yy; cmy > 0 {
+					f := math.Max(0, limit-k) / cmy
+					cc, mm, yy = cc*f, mm*f, yy*f
+				}
+			}
+
+			out.Pix[i+0] = ink(cc)
+			out.Pix[i+1] = ink(mm)
+			out.Pix[i+2] = ink(yy)
+			out.Pix[i+3] = ink(k)
+		}
+	}
+	return &Image{CMYK: out, Profile: c.Profile}
+}
+
+func ink(v float64) uint8 {
+	return uint8(math.Max(0, math.Min(255, v*255+0.5)))
+}
+
+////////////////////////////////////////////////////////////////////////////////
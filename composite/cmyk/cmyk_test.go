@@ -0,0 +1,52 @@
+package cmyk
+
+////////////////////////////////////////////////////////////////////////////////
+
+import (
+	"image"
+	"image/color"
+	"testing"
+)
+
+////////////////////////////////////////////////////////////////////////////////
+
+func TestConvertBlack(t *testing.T) {
+	src := image.NewRGBA(image.Rect(0, 0, 1, 1))
+	src.Set(0, 0, color.RGBA{128, 128, 128, 255})
+	for _, tc := range []struct {
+		black float64
+		want  [4]uint8
+	}{
+		{0, [4]uint8{127, 127, 127, 0}},
+		{0.5, [4]uint8{85, 85, 85, 64}},
+		{1, [4]uint8{0, 0, 0, 127}},
+	} {
+		c, err := NewConverter(tc.black, 0, "")
+		if err != nil {
+			t.Fatal(err)
+		}
+		var got [4]uint8
+		copy(got[:], c.Convert(src).Pix)
+		if got != tc.want {
+			t.Errorf("black %v converted gray to %v, expected %v", tc.black, got, tc.want)
+		}
+	}
+}
+
+func TestConvertInkLimit(t *testing.T) {
+	src := image.NewRGBA(image.Rect(0, 0, 1, 1))
+	src.Set(0, 0, color.RGBA{20, 0, 10, 255})
+	c, err := NewConverter(0, 200, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	total := 0
+	for _, v := range c.Convert(src).Pix {
+		total += int(v)
+	}
+	if total > 2*255+2 {
+		t.Errorf("total ink %d exceeds the 200%% limit", total)
+	}
+}
+
+////////////////////////////////////////////////////////////////////////////////
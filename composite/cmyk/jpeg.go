@@ -0,0 +1,336 @@
+package cmyk
+
+////////////////////////////////////////////////////////////////////////////////
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"math"
+)
+
+////////////////////////////////////////////////////////////////////////////////
+
+// The standard library's jpeg encoder only writes gray and YCbCr images, so
+// CMYK images are written with a minimal baseline encoder.  Each of the four
+// channels is stored at full resolution with the standard luminance tables,
+// and the file is marked as Adobe CMYK (which stores inverted samples) as that
+// is what print tools and the standard library's decoder expect.
+
+// DefaultQuality is the jpeg quality used when none is specified.
+const DefaultQuality = 90
+
+// unzig maps the zig-zag order of the coefficients to their natural order.
+var unzig = [64]int{
+	0, 1, 8, 16, 9, 2, 3, 10,
+	17, 24, 32, 25, 18, 11, 4, 5,
+	12, 19, 26, 33, 40, 48, 41, 34,
+	27, 20, 13, 6, 7, 14, 21, 28,
+	35, 42, 49, 56, 57, 50, 43, 36,
+	29, 22, 15, 23, 30, 37, 44, 51,
+	58, 59, 52, 45, 38, 31, 39, 46,
+	53, 60, 61, 54, 47, 55, 62, 63,
+}
+
+// baseQuant is the standard luminance quantization table, in natural order.
+var baseQuant = [64]int{
+	16, 11, 10, 16, 24, 40, 51, 61,
+	12, 12, 14, 19, 26, 58, 60, 55,
+	14, 13, 16, 24, 40, 57, 69, 56,
+	14, 17, 22, 29, 51, 87, 80, 62,
+	18, 22, 37, 56, 68, 109, 103, 77,
+	24, 35, 55, 64, 81, 104, 113, 92,
+	49, 64, 78, 87, 103, 121, 120, 101,
+	72, 92, 95, 98, 112, 100, 103, 99,
+}
+
+// huffmanSpec is the number of codes of each length (1 to 16 bits), and the
+// values that they encode.
+type huffmanSpec struct {
+	count [16]byte
+	value []byte
+}
+
+var (
+	dcSpec = huffmanSpec{
+		count: [16]byte{0, 1, 5, 1, 1, 1, 1, 1, 1, 0, 0, 0, 0, 0, 0, 0},
+		value: []byte{0, 1, 2, 3, 4, 5, 6, 7, 8, 9, 10, 11},
+	}
+	acSpec = huffmanSpec{
+		count: [16]byte{0, 2, 1, 3, 3, 2, 4, 3, 5, 5, 4, 4, 0, 0, 1, 125},
+		value: []byte{
+			0x01, 0x02, 0x03, 0x00, 0x04, 0x11, 0x05, 0x12,
+			0x21, 0x31, 0x41, 0x06, 0x13, 0x51, 0x61, 0x07,
+			0x22, 0x71, 0x14, 0x32, 0x81, 0x91, 0xa1, 0x08,
+			0x23, 0x42, 0xb1, 0xc1, 0x15, 0x52, 0xd1, 0xf0,
+			0x24, 0x33, 0x62, 0x72, 0x82, 0x09, 0x0a, 0x16,
+			0x17, 0x18, 0x19, 0x1a, 0x25, 0x26, 0x27, 0x28,
+			0x29, 0x2a, 0x34, 0x35, 0x36, 0x37, 0x38, 0x39,
+			0x3a, 0x43, 0x44, 0x45, 0x46, 0x47, 0x48, 0x49,
+			0x4a, 0x53, 0x54, 0x55, 0x56, 0x57, 0x58, 0x59,
+			0x5a, 0x63, 0x64, 0x65, 0x66, 0x67, 0x68, 0x69,
+			0x6a, 0x73, 0x74, 0x75, 0x76, 0x77, 0x78, 0x79,
+			0x7a, 0x83, 0x84, 0x85, 0x86, 0x87, 0x88, 0x89,
+			0x8a, 0x92, 0x93, 0x94, 0x95, 0x96, 0x97, 0x98,
+			0x99, 0x9a, 0xa2, 0xa3, 0xa4, 0xa5, 0xa6, 0xa7,
+			0xa8, 0xa9, 0xaa, 0xb2, 0xb3, 0xb4, 0xb5, 0xb6,
+			0xb7, 0xb8, 0xb9, 0xba, 0xc2, 0xc3, 0xc4, 0xc5,
+			0xc6, 0xc7, 0xc8, 0xc9, 0xca, 0xd2, 0xd3, 0xd4,
+			0xd5, 0xd6, 0xd7, 0xd8, 0xd9, 0xda, 0xe1, 0xe2,
+			0xe3, 0xe4, 0xe5, 0xe6, 0xe7, 0xe8, 0xe9, 0xea,
+			0xf1, 0xf2, 0xf3, 0xf4, 0xf5, 0xf6, 0xf7, 0xf8,
+			0xf9, 0xfa,
+		},
+	}
+)
+
+// huffmanCode is the code (in the low `size` bits) for a value.
+type huffmanCode struct {
+	code uint32
+	size uint32
+}
+
+// codes returns the code for each value of the spec.
+func (s *huffmanSpec) codes() [256]huffmanCode {
+	var t [256]huffmanCode
+	code, k := uint32(0), 0
+	for i, n := range s.count {
+		for j := 0; j < int(n); j++ {
+			t[s.value[k]] = huffmanCode{code: code, size: uint32(i + 1)}
+			code++
+			k++
+		}
+		code <<= 1
+	}
+	return t
+}
+
+// cosTable holds the DCT basis, `C(u) * cos((2x + 1) * u * pi / 16) / 2`.
+var cosTable [8][8]float64
+
+func init() {
+	for u := 0; u < 8; u++ {
+		c := 1.0
+		if u == 0 {
+			c = 1 / math.Sqrt2
+		}
+		for x := 0; x < 8; x++ {
+			cosTable[u][x] = c * math.Cos(float64(2*x+1)*float64(u)*math.Pi/16) / 2
+		}
+	}
+}
+
+////////////////////////////////////////////////////////////////////////////////
+
+// jpegWriter buffers the output and the huffman coded bits.
+type jpegWriter struct {
+	w     *bufio.Writer
+	bits  uint32
+	nbits uint32
+	err   error
+}
+
+func (w *jpegWriter) write(p ...byte) {
+	if w.err == nil {
+		_, w.err = w.w.Write(p)
+	}
+}
+
+func (w *jpegWriter) marker(m byte, data []byte) {
+	n := len(data) + 2
+	w.write(0xff, m, byte(n>>8), byte(n))
+	w.write(data...)
+}
+
+// emit writes the low `size` bits of `bits`, stuffing a zero byte after each
+// 0xff in the entropy coded data.
+func (w *jpegWriter) emit(bits, size uint32) {
+	w.bits = w.bits<<size | bits&(1<<size-1)
+	w.nbits += size
+	for w.nbits >= 8 {
+		b := byte(w.bits >> (w.nbits - 8))
+		w.write(b)
+		if b == 0xff {
+			w.write(0x00)
+		}
+		w.nbits -= 8
+	}
+}
+
+// emitValue writes the huffman code for the size (and run length, for ac
+// coefficients) of `v`, followed by its bits.
+func (w *jpegWriter) emitValue(t *[256]huffmanCode, run int, v int) {
+	a, b := v, v
+	if a < 0 {
+		a, b = -v, v-1
+	}
+	size := uint32(0)
+	for a > 0 {
+		size++
+		a >>= 1
+	}
+	h := t[byte(run<<4)|byte(size)]
+	w.emit(h.code, h.size)
+	if size > 0 {
+		w.emit(uint32(b), size)
+	}
+}
+
+////////////////////////////////////////////////////////////////////////////////
+
+// EncodeJPEG writes the image as an Adobe CMYK jpeg with the specified quality
+// (1 to 100).  The image's ICC profile (if any) is embedded.
+func EncodeJPEG(w io.Writer, img *Image, quality int) error {
+	b := img.Bounds()
+	if b.Dx() < 1 || b.Dy() < 1 || b.Dx() > 0xffff || b.Dy() > 0xffff {
+		return fmt.Errorf("cmyk: invalid jpeg size %dx%d", b.Dx(), b.Dy())
+	}
+	if quality < 1 || quality > 100 {
+		return fmt.Errorf("cmyk: invalid jpeg quality %d", quality)
+	}
+
+	jw := &jpegWriter{w: bufio.NewWriter(w)}
+	jw.write(0xff, 0xd8)
+
+	// Adobe marker with "transform 0", the samples are (inverted) CMYK.
+	jw.marker(0xee, []byte{'A', 'd', 'o', 'b', 'e', 0, 100, 0, 0, 0, 0, 0})
+	for _, chunk := range iccChunks(img.Profile) {
+		jw.marker(0xe2, chunk)
+	}
+
+	// Scale the quantization table to the quality, as libjpeg does.
+	scale := 200 - 2*quality
+	if quality < 50 {
+		scale = 5000 / quality
+	}
+	var quant [64]float64
+	dqt := make([]byte, 65)
+	for i, z := range unzig {
+		q := (baseQuant[z]*scale + 50) / 100
+		if q < 1 {
+			q = 1
+		} else if q > 255 {
+			q = 255
+		}
+		quant[z] = float64(q)
+		dqt[i+1] = byte(q)
+	}
+	jw.marker(0xdb, dqt)
+
+	// Frame header, four components with no subsampling that share the
+	// quantization table.
+	sof := []byte{8, byte(b.Dy() >> 8), byte(b.Dy()), byte(b.Dx() >> 8), byte(b.Dx()), 4}
+	for c := byte(1); c <= 4; c++ {
+		sof = append(sof, c, 0x11, 0)
+	}
+	jw.marker(0xc0, sof)
+
+	dht := []byte{0x00}
+	dht = append(dht, dcSpec.count[:]...)
+	dht = append(dht, dcSpec.value...)
+	dht = append(dht, 0x10)
+	dht = append(dht, acSpec.count[:]...)
+	dht = append(dht, acSpec.value...)
+	jw.marker(0xc4, dht)
+
+	jw.marker(0xda, []byte{4, 1, 0x00, 2, 0x00, 3, 0x00, 4, 0x00, 0, 63, 0})
+
+	dc, ac := dcSpec.codes(), acSpec.codes()
+	var prev [4]int
+	var block, coef [64]float64
+	for by := b.Min.Y; by < b.Max.Y; by += 8 {
+		for bx := b.Min.X; bx < b.Max.X; bx += 8 {
+			for c := 0; c < 4; c++ {
+				// Load the block, repeating the last row and column of
+				// the image to fill partial blocks.
+				for y := 0; y < 8; y++ {
+					sy := by + y
+					if sy >= b.Max.Y {
+						sy = b.Max.Y - 1
+					}
+					for x := 0; x < 8; x++ {
+						sx := bx + x
+						if sx >= b.Max.X {
+							sx = b.Max.X - 1
+						}
+						v := 255 - int(img.Pix[img.PixOffset(sx, sy)+c])
+						block[8*y+x] = float64(v - 128)
+					}
+				}
+				fdct(&block, &coef)
+
+				// Quantize and entropy code the coefficients.
+				d := int(math.Floor(coef[0]/quant[0] + 0.5))
+				jw.emitValue(&dc, 0, d-prev[c])
+				prev[c] = d
+				run := 0
+				for i := 1; i < 64; i++ {
+					z := unzig[i]
+					v := int(math.Floor(coef[z]/quant[z] + 0.5))
+					if v == 0 {
+						run++
+						continue
+					}
+					for ; run > 15; run -= 16 {
+						jw.emit(ac[0xf0].code, ac[0xf0].size)
+					}
+					jw.emitValue(&ac, run, v)
+					run = 0
+				}
+				if run > 0 {
+					jw.emit(ac[0x00].code, ac[0x00].size)
+				}
+			}
+		}
+	}
+
+	// Pad the last byte with ones, and end the image.
+	jw.emit(0x7f, 7)
+	jw.write(0xff, 0xd9)
+	if jw.err != nil {
+		return jw.err
+	}
+	return jw.w.Flush()
+}
+
+// fdct computes the forward DCT of the (level shifted) block.
+func fdct(in, out *[64]float64) {
+	var tmp [64]float64
+	for y := 0; y < 8; y++ {
+		for u := 0; u < 8; u++ {
+			s := 0.0
+			for x := 0; x < 8; x++ {
+				s += in[8*y+x] * cosTable[u][x]
+			}
+			tmp[8*y+u] = s
+		}
+	}
+	for u := 0; u < 8; u++ {
+		for v := 0; v < 8; v++ {
+			s := 0.0
+			for y := 0; y < 8; y++ {
+				s += tmp[8*y+u] * cosTable[v][y]
+			}
+			out[8*v+u] = s
+		}
+	}
+}
+
+// iccChunks splits the profile into the payloads of the APP2 markers that it
+// is embedded in.
+func iccChunks(profile []byte) [][]byte {
+	const maxChunk = 0xffff - 2 - 14
+	n := (len(profile) + maxChunk - 1) / maxChunk
+	chunks := [][]byte{}
+	for i := 0; i < n; i++ {
+		data := profile[i*maxChunk:]
+		if len(data) > maxChunk {
+			data = data[:maxChunk]
+		}
+		chunk := append([]byte("ICC_PROFILE\x00"), byte(i+1), byte(n))
+		chunks = append(chunks, append(chunk, data...))
+	}
+	return chunks
+}
+
+////////////////////////////////////////////////////////////////////////////////
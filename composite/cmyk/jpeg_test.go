@@ -0,0 +1,152 @@
+package cmyk
+
+////////////////////////////////////////////////////////////////////////////////
+
+import (
+	"bytes"
+	"encoding/binary"
+	"image"
+	"image/jpeg"
+	"math/rand"
+	"testing"
+)
+
+////////////////////////////////////////////////////////////////////////////////
+
+// testImage returns a CMYK image with a smooth gradient in each channel, whose
+// size is not a multiple of the jpeg block size.
+func testImage(w, h int) *Image {
+	img := image.NewCMYK(image.Rect(0, 0, w, h))
+	for y := 0; y < h; y++ {
+		for x := 0; x < w; x++ {
+			i := img.PixOffset(x, y)
+			img.Pix[i+0] = uint8(255 * x / w)
+			img.Pix[i+1] = uint8(255 * y / h)
+			img.Pix[i+2] = uint8(255 * (x + y) / (w + h))
+			img.Pix[i+3] = uint8(64 + 64*x/w)
+		}
+	}
+	return &Image{CMYK: img}
+}
+
+// testProfile returns `n` bytes with an icc profile header.
+func testProfile(n int) []byte {
+	p := make([]byte, n)
+	rand.New(rand.NewSource(1)).Read(p)
+	copy(p[36:], "acsp")
+	return p
+}
+
+// app2Profile reassembles the icc profile from the APP2 markers of the jpeg,
+// and returns it with the number of markers that it was split across.
+func app2Profile(t *testing.T, data []byte) ([]byte, int) {
+	chunks := map[int][]byte{}
+	total := 0
+	for i := 2; i+4 <= len(data) && data[i] == 0xff && data[i+1] != 0xda; {
+		n := int(binary.BigEndian.Uint16(data[i+2:]))
+		seg := data[i+4 : i+2+n]
+		if data[i+1] == 0xe2 && bytes.HasPrefix(seg, []byte("ICC_PROFILE\x00")) {
+			seq, count := int(seg[12]), int(seg[13])
+			if total != 0 && count != total {
+				t.Fatalf("icc chunk %d has a count of %d, expected %d", seq, count, total)
+			}
+			total = count
+			chunks[seq] = seg[14:]
+		}
+		i += 2 + n
+	}
+	if len(chunks) != total {
+		t.Fatalf("found %d icc chunks, expected %d", len(chunks), total)
+	}
+	var profile []byte
+	for seq := 1; seq <= total; seq++ {
+		profile = append(profile, chunks[seq]...)
+	}
+	return profile, total
+}
+
+////////////////////////////////////////////////////////////////////////////////
+
+func TestEncodeJPEGRoundTrip(t *testing.T) {
+	src := testImage(37, 21)
+	var buf bytes.Buffer
+	if err := EncodeJPEG(&buf, src, 100); err != nil {
+		t.Fatal(err)
+	}
+
+	img, err := jpeg.Decode(&buf)
+	if err != nil {
+		t.Fatalf("unable to decode: %s", err.Error())
+	}
+	dst, ok := img.(*image.CMYK)
+	if !ok {
+		t.Fatalf("decoded a %T, expected *image.CMYK", img)
+	}
+	if dst.Bounds() != src.Bounds() {
+		t.Fatalf("decoded bounds %v, expected %v", dst.Bounds(), src.Bounds())
+	}
+
+	// At the highest quality, the samples only differ by rounding.
+	const tolerance = 3
+	for i := range src.Pix {
+		if d := int(dst.Pix[i]) - int(src.Pix[i]); d < -tolerance || d > tolerance {
+			x, y := (i/4)%37, (i/4)/37
+			t.Fatalf("channel %d of (%d, %d) is %d, expected %d", i%4, x, y, dst.Pix[i], src.Pix[i])
+		}
+	}
+}
+
+func TestEncodeJPEGQuality(t *testing.T) {
+	src := testImage(64, 64)
+	var lo, hi bytes.Buffer
+	if err := EncodeJPEG(&lo, src, 10); err != nil {
+		t.Fatal(err)
+	}
+	if err := EncodeJPEG(&hi, src, 95); err != nil {
+		t.Fatal(err)
+	}
+	if lo.Len() >= hi.Len() {
+		t.Errorf("quality 10 is %d bytes, not smaller than quality 95 at %d bytes", lo.Len(), hi.Len())
+	}
+	if _, err := jpeg.Decode(&lo); err != nil {
+		t.Errorf("unable to decode quality 10: %s", err.Error())
+	}
+	if err := EncodeJPEG(&lo, src, 0); err == nil {
+		t.Errorf("expected an error for quality 0")
+	}
+}
+
+func TestEncodeJPEGProfile(t *testing.T) {
+	for _, tc := range []struct {
+		size   int
+		chunks int
+	}{
+		{1000, 1},
+		{0xffff - 16, 1},
+		{0xffff - 15, 2},
+		{150000, 3},
+	} {
+		src := testImage(9, 9)
+		src.Profile = testProfile(tc.size)
+		if n := len(iccChunks(src.Profile)); n != tc.chunks {
+			t.Errorf("%d byte profile split into %d chunks, expected %d", tc.size, n, tc.chunks)
+		}
+
+		var buf bytes.Buffer
+		if err := EncodeJPEG(&buf, src, 90); err != nil {
+			t.Fatal(err)
+		}
+		profile, n := app2Profile(t, buf.Bytes())
+		if n != tc.chunks {
+			t.Errorf("%d byte profile written in %d markers, expected %d", tc.size, n, tc.chunks)
+		}
+		if !bytes.Equal(profile, src.Profile) {
+			t.Errorf("%d byte profile does not round trip, got %d bytes", tc.size, len(profile))
+		}
+		if _, err := jpeg.Decode(&buf); err != nil {
+			t.Errorf("unable to decode with a %d byte profile: %s", tc.size, err.Error())
+		}
+	}
+}
+
+////////////////////////////////////////////////////////////////////////////////
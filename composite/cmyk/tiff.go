@@ -0,0 +1,168 @@
+package cmyk
+
+////////////////////////////////////////////////////////////////////////////////
+
+import (
+	"bytes"
+	"compress/zlib"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"math"
+	"sort"
+)
+
+////////////////////////////////////////////////////////////////////////////////
+
+// Tag types and the tags that are written.
+const (
+	tShort     = 3
+	tLong      = 4
+	tRational  = 5
+	tUndefined = 7
+
+	tagImageWidth      = 256
+	tagImageLength     = 257
+	tagBitsPerSample   = 258
+	tagCompression     = 259
+	tagPhotometric     = 262
+	tagStripOffsets    = 273
+	tagSamplesPerPixel = 277
+	tagRowsPerStrip    = 278
+	tagStripByteCounts = 279
+	tagXResolution     = 282
+	tagYResolution     = 283
+	tagPlanarConfig    = 284
+	tagResolutionUnit  = 296
+	tagInkSet          = 332
+	tagICCProfile      = 34675
+)
+
+// ifdEntry is a single tag of the image file directory, with its values
+// already encoded (little endian).
+type ifdEntry struct {
+	tag   uint16
+	typ   uint16
+	count uint32
+	data  []byte
+}
+
+func shortEntry(tag uint16, vs ...uint16) ifdEntry {
+	data := make([]byte, 2*len(vs))
+	for i, v := range vs {
+		binary.LittleEndian.PutUint16(data[2*i:], v)
+	}
+	return ifdEntry{tag, tShort, uint32(len(vs)), data}
+}
+
+func longEntry(tag uint16, v uint32) ifdEntry {
+	data := make([]byte, 4)
+	binary.LittleEndian.PutUint32(data, v)
+	return ifdEntry{tag, tLong, 1, data}
+}
+
+func rationalEntry(tag uint16, num, den uint32) ifdEntry {
+	data := make([]byte, 8)
+	binary.LittleEndian.PutUint32(data, num)
+	binary.LittleEndian.PutUint32(data[4:], den)
+	return ifdEntry{tag, tRational, 1, data}
+}
+
+////////////////////////////////////////////////////////////////////////////////
+
+// EncodeTIFF writes the image as a deflate compressed, separated (CMYK) tiff
+// with its resolution set to `dpi`.  The image's ICC profile (if any) is
+// embedded.
+func EncodeTIFF(w io.Writer, img *Image, dpi float64) error {
+	b := img.Bounds()
+	if b.Dx() < 1 || b.Dy() < 1 {
+		return fmt.Errorf("cmyk: invalid tiff size %dx%d", b.Dx(), b.Dy())
+	}
+	if dpi <= 0 {
+		return fmt.Errorf("cmyk: invalid dpi %v", dpi)
+	}
+
+	// The image is written as a single strip.
+	var strip bytes.Buffer
+	zw := zlib.NewWriter(&strip)
+	for y := b.Min.Y; y < b.Max.Y; y++ {
+		i := img.PixOffset(b.Min.X, y)
+		if _, err := zw.Write(img.Pix[i : i+4*b.Dx()]); err != nil {
+			return err
+		}
+	}
+	if err := zw.Close(); err != nil {
+		return err
+	}
+
+	res := uint32(math.Floor(dpi*100 + 0.5))
+	entries := []ifdEntry{
+		longEntry(tagImageWidth, uint32(b.Dx())),
+		longEntry(tagImageLength, uint32(b.Dy())),
+		shortEntry(tagBitsPerSample, 8, 8, 8, 8),
+		shortEntry(tagCompression, 8), // deflate
+		shortEntry(tagPhotometric, 5), // separated
+		longEntry(tagStripOffsets, 0), // set below
+		shortEntry(tagSamplesPerPixel, 4),
+		longEntry(tagRowsPerStrip, uint32(b.Dy())),
+		longEntry(tagStripByteCounts, uint32(strip.Len())),
+		rationalEntry(tagXResolution, res, 100),
+		rationalEntry(tagYResolution, res, 100),
+		shortEntry(tagPlanarConfig, 1), // contiguous
+		shortEntry(tagResolutionUnit, 2),
+		shortEntry(tagInkSet, 1), // cmyk
+	}
+	if len(img.Profile) > 0 {
+		entries = append(entries, ifdEntry{tagICCProfile, tUndefined, uint32(len(img.Profile)), img.Profile})
+	}
+	sort.Slice(entries, func(i, j int) bool { return entries[i].tag < entries[j].tag })
+
+	// The header is followed by the directory, the values that do not fit in
+	// their entries and finally the strip.
+	const header = 8
+	ifdLen := 2 + 12*len(entries) + 4
+	extra := 0
+	for _, e := range entries {
+		if len(e.data) > 4 {
+			extra += len(e.data) + len(e.data)%2
+		}
+	}
+	stripOffset := uint32(header + ifdLen + extra)
+	for i := range entries {
+		if entries[i].tag == tagStripOffsets {
+			binary.LittleEndian.PutUint32(entries[i].data, stripOffset)
+		}
+	}
+
+	var buf bytes.Buffer
+	buf.Write([]byte{'I', 'I', 42, 0, header, 0, 0, 0})
+	binary.Write(&buf, binary.LittleEndian, uint16(len(entries)))
+	offset := uint32(header + ifdLen)
+	var values bytes.Buffer
+	for _, e := range entries {
+		binary.Write(&buf, binary.LittleEndian, e.tag)
+		binary.Write(&buf, binary.LittleEndian, e.typ)
+		binary.Write(&buf, binary.LittleEndian, e.count)
+		if len(e.data) > 4 {
+			binary.Write(&buf, binary.LittleEndian, offset+uint32(values.Len()))
+			values.Write(e.data)
+			if len(e.data)%2 == 1 {
+				values.WriteByte(0)
+			}
+		} else {
+			var v [4]byte
+			copy(v[:], e.data)
+			buf.Write(v[:])
+		}
+	}
+	buf.Write([]byte{0, 0, 0, 0}) // no more directories
+	buf.Write(values.Bytes())
+
+	if _, err := w.Write(buf.Bytes()); err != nil {
+		return err
+	}
+	_, err := w.Write(strip.Bytes())
+	return err
+}
+
+////////////////////////////////////////////////////////////////////////////////
@@ -0,0 +1,159 @@
+package cmyk
+
+////////////////////////////////////////////////////////////////////////////////
+
+import (
+	"bytes"
+	"compress/zlib"
+	"encoding/binary"
+	"image"
+	"io/ioutil"
+	"testing"
+)
+
+////////////////////////////////////////////////////////////////////////////////
+
+// The vendored golang.org/x/image/tiff predates its support for separated
+// (CMYK) images, so the files are read back with a minimal reader of the
+// little endian, single strip layout that `EncodeTIFF` writes.
+
+type tiffEntry struct {
+	typ   uint16
+	count uint32
+	data  []byte // the value, read from its offset if it does not fit
+}
+
+func (e tiffEntry) uint(i int) uint32 {
+	if e.typ == tShort {
+		return uint32(binary.LittleEndian.Uint16(e.data[2*i:]))
+	}
+	return binary.LittleEndian.Uint32(e.data[4*i:])
+}
+
+func readTIFF(t *testing.T, data []byte) map[uint16]tiffEntry {
+	if !bytes.HasPrefix(data, []byte{'I', 'I', 42, 0}) {
+		t.Fatalf("not a little endian tiff: % x", data[:4])
+	}
+	le := binary.LittleEndian
+	ifd := le.Uint32(data[4:])
+	n := int(le.Uint16(data[ifd:]))
+	entries := map[uint16]tiffEntry{}
+	prev := uint16(0)
+	for i := 0; i < n; i++ {
+		p := data[int(ifd)+2+12*i:]
+		tag := le.Uint16(p)
+		if tag <= prev {
+			t.Fatalf("tag %d is out of order", tag)
+		}
+		prev = tag
+
+		e := tiffEntry{typ: le.Uint16(p[2:]), count: le.Uint32(p[4:])}
+		size := int(e.count) * map[uint16]int{tShort: 2, tLong: 4, tRational: 8, tUndefined: 1}[e.typ]
+		if size <= 4 {
+			e.data = p[8 : 8+size]
+		} else {
+			off := le.Uint32(p[8:])
+			e.data = data[off : int(off)+size]
+		}
+		entries[tag] = e
+	}
+	if next := le.Uint32(data[int(ifd)+2+12*n:]); next != 0 {
+		t.Fatalf("unexpected directory at %d", next)
+	}
+	return entries
+}
+
+////////////////////////////////////////////////////////////////////////////////
+
+func TestEncodeTIFFRoundTrip(t *testing.T) {
+	src := testImage(37, 21)
+	src.Profile = testProfile(1001) // odd, so that the values are padded
+	var buf bytes.Buffer
+	if err := EncodeTIFF(&buf, src, 300); err != nil {
+		t.Fatal(err)
+	}
+	data := buf.Bytes()
+	entries := readTIFF(t, data)
+
+	for _, tc := range []struct {
+		tag  uint16
+		want uint32
+	}{
+		{tagImageWidth, 37},
+		{tagImageLength, 21},
+		{tagCompression, 8},
+		{tagPhotometric, 5},
+		{tagSamplesPerPixel, 4},
+		{tagRowsPerStrip, 21},
+		{tagPlanarConfig, 1},
+		{tagResolutionUnit, 2},
+		{tagInkSet, 1},
+	} {
+		if e, ok := entries[tc.tag]; !ok {
+			t.Errorf("tag %d is missing", tc.tag)
+		} else if v := e.uint(0); v != tc.want {
+			t.Errorf("tag %d is %d, expected %d", tc.tag, v, tc.want)
+		}
+	}
+	for i := 0; i < 4; i++ {
+		if v := entries[tagBitsPerSample].uint(i); v != 8 {
+			t.Errorf("sample %d has %d bits, expected 8", i, v)
+		}
+	}
+	for _, tag := range []uint16{tagXResolution, tagYResolution} {
+		if num, den := entries[tag].uint(0), entries[tag].uint(1); num != 300*den {
+			t.Errorf("tag %d is %d/%d, expected 300 dpi", tag, num, den)
+		}
+	}
+	if p := entries[tagICCProfile].data; !bytes.Equal(p, src.Profile) {
+		t.Errorf("profile does not round trip, got %d bytes", len(p))
+	}
+
+	off, n := entries[tagStripOffsets].uint(0), entries[tagStripByteCounts].uint(0)
+	if int(off+n) != len(data) {
+		t.Fatalf("strip at %d of %d bytes does not end the %d byte file", off, n, len(data))
+	}
+	zr, err := zlib.NewReader(bytes.NewReader(data[off : off+n]))
+	if err != nil {
+		t.Fatal(err)
+	}
+	pix, err := ioutil.ReadAll(zr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(pix, src.Pix) {
+		t.Errorf("pixels do not round trip")
+	}
+}
+
+func TestEncodeTIFFSubImage(t *testing.T) {
+	src := testImage(20, 20)
+	sub := &Image{CMYK: src.SubImage(src.Rect.Inset(5)).(*image.CMYK)}
+	var buf bytes.Buffer
+	if err := EncodeTIFF(&buf, sub, 72); err != nil {
+		t.Fatal(err)
+	}
+	data := buf.Bytes()
+	entries := readTIFF(t, data)
+	if _, ok := entries[tagICCProfile]; ok {
+		t.Errorf("unexpected profile")
+	}
+
+	off, n := entries[tagStripOffsets].uint(0), entries[tagStripByteCounts].uint(0)
+	zr, err := zlib.NewReader(bytes.NewReader(data[off : off+n]))
+	if err != nil {
+		t.Fatal(err)
+	}
+	pix, err := ioutil.ReadAll(zr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for y := 0; y < 10; y++ {
+		i := src.PixOffset(5, 5+y)
+		if !bytes.Equal(pix[40*y:40*(y+1)], src.Pix[i:i+40]) {
+			t.Fatalf("row %d does not round trip", y)
+		}
+	}
+}
+
+////////////////////////////////////////////////////////////////////////////////
@@ -16,14 +16,19 @@ import (
 	"fmt"
 	"image"
 	"image/color"
+	"image/draw"
 	"image/jpeg"
 	"image/png"
+	"io"
 	"os"
-	"os/exec"
-	"path"
 	"strings"
 
 	"github.com/disintegration/imaging"
+	"golang.org/x/image/tiff"
+
+	"github.com/sabhiram/imagenie/composite/cmyk"
+	"github.com/sabhiram/imagenie/composite/filter"
+	"github.com/sabhiram/imagenie/composite/pdf"
 )
 
 ////////////////////////////////////////////////////////////////////////////////
@@ -32,35 +37,50 @@ type Renderable interface {
 	Render() (image.Image, int, int, int, error)
 }
 
+// Reporter is implemented by renderables that make decisions while rendering
+// (like the size to fit text at), and can describe them once rendered.
+type Reporter interface {
+	Report() string
+}
+
 ////////////////////////////////////////////////////////////////////////////////
 
-func BuildImage(bgpath, ofpath, offmt string, items []Renderable) error {
-	baseImgFd, err := os.Open(bgpath)
+// LoadBackground reads and decodes the background image at `fp`, and applies
+// the filters to it in order.
+func LoadBackground(fp string, filters []filter.Filter) (image.Image, error) {
+	img, err := LoadImage(fp)
 	if err != nil {
-		return err
+		return nil, err
 	}
-	defer baseImgFd.Close()
+	return filter.Apply(img, filters)
+}
 
-	baseImg, _, err := image.Decode(baseImgFd)
+// LoadImage reads and decodes the image at `fp`.
+func LoadImage(fp string) (image.Image, error) {
+	fd, err := os.Open(fp)
 	if err != nil {
-		return err
+		return nil, err
 	}
+	defer fd.Close()
 
-	// Create an output image, copy each pixel from the background to the temp
-	// image so that we can build up each layer of the overlays.
+	img, _, err := image.Decode(fd)
+	return img, err
+}
+
+// RenderOnto composites the renderables onto a copy of the (already decoded)
+// background image, which is left untouched.
+func RenderOnto(baseImg image.Image, items []Renderable) (*image.RGBA, error) {
+	// Create an output image and copy the background into it so that we can
+	// build up each layer of the overlays.
 	bounds := baseImg.Bounds()
 	out := image.NewRGBA(bounds)
-	for x := 0; x < bounds.Max.X; x++ {
-		for y := 0; y < bounds.Max.Y; y++ {
-			out.Set(x, y, baseImg.At(x, y))
-		}
-	}
+	draw.Draw(out, bounds, baseImg, bounds.Min, draw.Src)
 
-	// Overlay each renderable on top of the image.
+	// Composite each renderable on top of the image.
 	for _, item := range items {
 		primg, rot, xoff, yoff, err := item.Render()
 		if err != nil {
-			return err
+			return nil, err
 		}
 
 		img := primg
@@ -68,104 +88,63 @@ func BuildImage(bgpath, ofpath, offmt string, items []Renderable) error {
 			img = imaging.Rotate(primg, float64(rot), color.Transparent)
 		}
 
-		inbounds := img.Bounds()
-		w, h := inbounds.Max.X, inbounds.Max.Y
-		for x := 0; x < w; x++ {
-			for y := 0; y < h; y++ {
-				if x+xoff < bounds.Max.X && y+yoff < bounds.Max.Y {
-
-					rf, gf, bf, af := img.At(x, y).RGBA()
-					rb, gb, bb, ab := out.At(x+xoff, y+yoff).RGBA()
-					alpha := float64(af) / float64(0xffff)
-					beta := 1.0 - alpha
-
-					// Some hacky alpha blending - revisit later :)
-					c := color.RGBA{
-						uint8((float64(rf)*alpha + float64(rb)*beta) / 256),
-						uint8((float64(gf)*alpha + float64(gb)*beta) / 256),
-						uint8((float64(bf)*alpha + float64(bb)*beta) / 256),
-						uint8((float64(af)*alpha + float64(ab)*beta) / 256),
-					}
-					out.Set(x+xoff, y+yoff, c)
-				}
-			}
-		}
+		mode, opacity := blendOf(item)
+		Composite(out, img, placeOf(item, bounds, img, xoff, yoff), mode, opacity)
 	}
 
-	// Emit the file as an image in the specified output format, location.
-	outfd, err := os.OpenFile(ofpath, os.O_WRONLY|os.O_TRUNC|os.O_CREATE, 0666)
+	return out, nil
+}
+
+// WriteImage emits the image as a file in the specified output format and
+// location.  The file is only replaced once the image has been encoded in full.
+func WriteImage(out image.Image, ofpath, offmt string, dpi float64) error {
+	outfd, err := CreateAtomic(ofpath)
 	if err != nil {
 		return err
 	}
-	defer outfd.Close()
+	if err := EncodeImage(outfd, out, offmt, dpi); err != nil {
+		outfd.Abort()
+		return err
+	}
+	return outfd.Commit()
+}
 
+// EncodeImage writes the image to `w` in the specified output format.  CMYK
+// images (see the cmyk package) are written as CMYK jpeg and tiff files.
+func EncodeImage(w io.Writer, out image.Image, offmt string, dpi float64) error {
+	cimg, isCMYK := out.(*cmyk.Image)
 	switch strings.ToLower(offmt) {
 	case "png":
-		if err := png.Encode(outfd, out); err != nil {
-			return err
-		}
+		return png.Encode(w, out)
 	case "jpeg", "jpg":
-		if err := jpeg.Encode(outfd, out, nil); err != nil {
-			return err
+		if isCMYK {
+			return cmyk.EncodeJPEG(w, cimg, cmyk.DefaultQuality)
 		}
-	default:
-		return fmt.Errorf("%s is not a valid output format type", offmt)
+		return jpeg.Encode(w, out, nil)
+	case "tiff", "tif":
+		if isCMYK {
+			return cmyk.EncodeTIFF(w, cimg, dpi)
+		}
+		return tiff.Encode(w, out, &tiff.Options{Compression: tiff.Deflate})
+	case "pdf":
+		return pdf.Encode(w, out, dpi)
 	}
-
-	return nil
+	return fmt.Errorf("%s is not a valid output format type", offmt)
 }
 
 ////////////////////////////////////////////////////////////////////////////////
 
-func BuildImageWithMagick(binspath, bgpath, ofpath, offmt, ofcs string, items []Renderable) error {
-	// Create the output image as a copy of the background.
-	cmd := fmt.Sprintf("%s ( +clone ) -composite %s", bgpath, ofpath)
-	cmdCopy := exec.Command(path.Join(binspath, "convert"), strings.Split(cmd, " ")...)
-	_, err := cmdCopy.CombinedOutput()
-	if err != nil {
-		return err
-	}
-
-	// Overlay each renderable on top of the image.
-	for _, item := range items {
-		primg, rot, xoff, yoff, err := item.Render()
-		if err != nil {
-			return err
-		}
-		img := primg
-		if rot > 0 && rot < 360 {
-			img = imaging.Rotate(primg, float64(rot), color.Transparent)
-		}
-
-		tempImgPath := "tmp.png"
-		tempFd, err := os.OpenFile(tempImgPath, os.O_WRONLY|os.O_TRUNC|os.O_CREATE, 0666)
-		if err != nil {
-			return err
-		}
-		defer tempFd.Close()
-
-		if err := png.Encode(tempFd, img); err != nil {
-			return err
-		}
-
-		// Imagemagick only understands "rgb" as a valid colorspace
-		if ofcs == "rgba" || ofcs == "rgb" {
-			ofcs = "rgb"
-		} else if ofcs == "cmyk" {
-			ofcs = "cmyk"
-		} else {
-			return fmt.Errorf("%s is an invalid colorspace!", ofcs)
-		}
-
-		cmd = fmt.Sprintf("-colorspace %s -compose atop -geometry +%d+%d %s %s %s", ofcs, xoff, yoff, tempImgPath, ofpath, ofpath)
-		cmd1 := exec.Command(path.Join(binspath, "composite"), strings.Split(cmd, " ")...)
-		_, err = cmd1.CombinedOutput()
-		if err != nil {
-			return err
-		}
-	}
+func alphaColor(a float64) color.Alpha16 {
+	return color.Alpha16{uint16(a*0xffff + 0.5)}
+}
 
-	return nil
+// applyOpacity returns a copy of the image with its alpha scaled by `opacity`.
+func applyOpacity(img image.Image, opacity float64) image.Image {
+	b := img.Bounds()
+	out := image.NewRGBA(image.Rectangle{image.ZP, b.Size()})
+	mask := image.NewUniform(alphaColor(opacity))
+	draw.DrawMask(out, out.Bounds(), img, b.Min, mask, image.ZP, draw.Src)
+	return out
 }
 
 ////////////////////////////////////////////////////////////////////////////////
@@ -0,0 +1,175 @@
+package filter
+
+////////////////////////////////////////////////////////////////////////////////
+/*
+
+Package filter applies a list of adjustments (blur, contrast, grayscale, ...)
+to images, in order.  The adjustments are those provided by the imaging
+package, along with saturation.
+
+*/
+////////////////////////////////////////////////////////////////////////////////
+
+import (
+	"fmt"
+	"image"
+	"image/color"
+	"math"
+	"strings"
+
+	"github.com/disintegration/imaging"
+)
+
+////////////////////////////////////////////////////////////////////////////////
+
+// Filter is a single named adjustment, with its (optional) value.
+type Filter struct {
+	Name  string
+	Value float64
+	set   bool  // set if a value was specified
+	err   error // set if the filter could not be decoded
+}
+
+// New returns the filter with the specified value.
+func New(name string, value float64) Filter {
+	return Filter{Name: name, Value: value, set: true}
+}
+
+// UnmarshalYAML allows filters to be specified as a plain name (`grayscale`),
+// or as a mapping of the name to its value (`blur: 2.0`).  Filters that are
+// not specified correctly are reported by `Validate`, so that the rest of the
+// config is still decoded.
+func (f *Filter) UnmarshalYAML(unmarshal func(interface{}) error) error {
+	var name string
+	if err := unmarshal(&name); err == nil {
+		*f = Filter{Name: name}
+		return nil
+	}
+
+	var m map[string]interface{}
+	if err := unmarshal(&m); err != nil {
+		return err
+	}
+	if len(m) != 1 {
+		*f = Filter{err: fmt.Errorf("filter must have a single name, got %d", len(m))}
+		return nil
+	}
+	for name, v := range m {
+		switch tv := v.(type) {
+		case nil:
+			*f = Filter{Name: name}
+		case int:
+			*f = New(name, float64(tv))
+		case float64:
+			*f = New(name, tv)
+		default:
+			*f = Filter{Name: name, err: fmt.Errorf("%s filter value must be a number, got %v", name, v)}
+		}
+	}
+	return nil
+}
+
+// MarshalYAML writes the filter as it is specified in the config.
+func (f Filter) MarshalYAML() (interface{}, error) {
+	if !f.set {
+		return f.Name, nil
+	}
+	return map[string]float64{f.Name: f.Value}, nil
+}
+
+func (f Filter) String() string {
+	if !f.set {
+		return f.Name
+	}
+	return fmt.Sprintf("%s: %v", f.Name, f.Value)
+}
+
+////////////////////////////////////////////////////////////////////////////////
+
+// Validate returns an error if the filter is unknown, or its value is out of
+// range.
+func (f Filter) Validate() error {
+	if f.err != nil {
+		return f.err
+	}
+	between := func(lo, hi float64) error {
+		if !f.set {
+			return fmt.Errorf("%s filter requires a value", f.Name)
+		}
+		if f.Value < lo || f.Value > hi {
+			return fmt.Errorf("%s must be between %v and %v, got %v", f.Name, lo, hi, f.Value)
+		}
+		return nil
+	}
+	positive := func() error {
+		if !f.set || f.Value <= 0 {
+			return fmt.Errorf("%s filter requires a positive value", f.Name)
+		}
+		return nil
+	}
+
+	switch strings.ToLower(f.Name) {
+	case "grayscale", "greyscale", "invert":
+		return nil
+	case "blur", "sharpen", "gamma":
+		return positive()
+	case "brightness", "contrast", "saturation":
+		return between(-100, 100)
+	}
+	return fmt.Errorf("%s is not a valid filter (grayscale, invert, blur, sharpen, brightness, contrast, gamma, saturation)", f.Name)
+}
+
+// Validate returns an error for the first invalid filter in the list.
+func Validate(filters []Filter) error {
+	for i, f := range filters {
+		if err := f.Validate(); err != nil {
+			return fmt.Errorf("filters[%d]: %s", i, err.Error())
+		}
+	}
+	return nil
+}
+
+// Apply returns the image with the filters applied to it in order.  The image
+// is returned as is if there are no filters.
+func Apply(img image.Image, filters []Filter) (image.Image, error) {
+	if err := Validate(filters); err != nil {
+		return nil, err
+	}
+	for _, f := range filters {
+		switch strings.ToLower(f.Name) {
+		case "grayscale", "greyscale":
+			img = imaging.Grayscale(img)
+		case "invert":
+			img = imaging.Invert(img)
+		case "blur":
+			img = imaging.Blur(img, f.Value)
+		case "sharpen":
+			img = imaging.Sharpen(img, f.Value)
+		case "gamma":
+			img = imaging.AdjustGamma(img, f.Value)
+		case "brightness":
+			img = imaging.AdjustBrightness(img, f.Value)
+		case "contrast":
+			img = imaging.AdjustContrast(img, f.Value)
+		case "saturation":
+			img = saturate(img, f.Value)
+		}
+	}
+	return img, nil
+}
+
+// saturate moves the color of each pixel away from (or towards) its luminance
+// by `percentage`, -100 being fully desaturated.
+func saturate(img image.Image, percentage float64) *image.NRGBA {
+	k := 1 + percentage/100
+	clamp := func(v float64) uint8 {
+		return uint8(math.Min(math.Max(v, 0), 255) + 0.5)
+	}
+	return imaging.AdjustFunc(img, func(c color.NRGBA) color.NRGBA {
+		r, g, b := float64(c.R), float64(c.G), float64(c.B)
+		y := 0.299*r + 0.587*g + 0.114*b
+		return color.NRGBA{clamp(y + (r-y)*k), clamp(y + (g-y)*k), clamp(y + (b-y)*k), c.A}
+	})
+}
+
+////////////////////////////////////////////////////////////////////////////////
@@ -7,6 +7,8 @@ import (
 	_ "image/jpeg"
 	_ "image/png"
 	"os"
+
+	"github.com/sabhiram/imagenie/composite/filter"
 )
 
 ////////////////////////////////////////////////////////////////////////////////
@@ -19,14 +21,20 @@ type Overlay struct {
 	rotation   int
 	xoff, yoff int
 	value      string
+	layout     Layout
+	filters    []filter.Filter
+	mask       *Mask
 }
 
-func NewOverlay(ro, x, y int, value string) *Overlay {
+func NewOverlay(ro, x, y int, value string, layout Layout, filters []filter.Filter, mask *Mask) *Overlay {
 	return &Overlay{
 		rotation: ro,
 		xoff:     x,
 		yoff:     y,
 		value:    value,
+		layout:   layout,
+		filters:  filters,
+		mask:     mask,
 	}
 }
 
@@ -44,6 +52,18 @@ func (o *Overlay) Render() (image.Image, int, int, int, error) {
 		return nil, 0, 0, 0, err
 	}
 
+	img, err = o.layout.apply(img)
+	if err != nil {
+		return nil, 0, 0, 0, err
+	}
+	img, err = filter.Apply(img, o.filters)
+	if err != nil {
+		return nil, 0, 0, 0, err
+	}
+	img, err = o.mask.apply(img)
+	if err != nil {
+		return nil, 0, 0, 0, err
+	}
 	return img, o.rotation, o.xoff, o.yoff, nil
 }
 
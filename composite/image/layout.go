@@ -0,0 +1,128 @@
+package image
+
+////////////////////////////////////////////////////////////////////////////////
+
+import (
+	"fmt"
+	"image"
+	"strings"
+
+	"github.com/disintegration/imaging"
+)
+
+////////////////////////////////////////////////////////////////////////////////
+
+// Layout specifies the (optional) box that the image is resized to, and how
+// the image fits in it.  Without a width and height the image is used at its
+// native size, and with only one of them it is scaled to that dimension
+// keeping its aspect ratio.
+type Layout struct {
+	Width   int    // box width in pixels
+	Height  int    // box height in pixels
+	Fit     string // contain (default), cover, fill, none
+	Gravity string // part of the image kept when it is cropped (default: center)
+	Filter  string // resampling filter (default: lanczos)
+}
+
+// gravities maps the names of the crop gravities to imaging's anchors.
+var gravities = map[string]imaging.Anchor{
+	"":             imaging.Center,
+	"center":       imaging.Center,
+	"middle":       imaging.Center,
+	"top-left":     imaging.TopLeft,
+	"top":          imaging.Top,
+	"top-right":    imaging.TopRight,
+	"left":         imaging.Left,
+	"right":        imaging.Right,
+	"bottom-left":  imaging.BottomLeft,
+	"bottom":       imaging.Bottom,
+	"bottom-right": imaging.BottomRight,
+}
+
+// filters maps the names of the resampling filters to imaging's filters.
+var filters = map[string]imaging.ResampleFilter{
+	"":            imaging.Lanczos,
+	"lanczos":     imaging.Lanczos,
+	"catmull-rom": imaging.CatmullRom,
+	"mitchell":    imaging.MitchellNetravali,
+	"linear":      imaging.Linear,
+	"box":         imaging.Box,
+	"nearest":     imaging.NearestNeighbor,
+}
+
+func normalize(s string) string {
+	return strings.Replace(strings.ToLower(s), "_", "-", -1)
+}
+
+// Validate returns an error if any of the layout's options are invalid.
+func (l Layout) Validate() error {
+	if l.Width < 0 || l.Height < 0 {
+		return fmt.Errorf("image box width and height must not be negative")
+	}
+	switch normalize(l.Fit) {
+	case "", "contain", "cover", "fill", "none":
+	default:
+		return fmt.Errorf("%s is not a valid image fit mode (contain, cover, fill, none)", l.Fit)
+	}
+	if _, ok := gravities[normalize(l.Gravity)]; !ok {
+		return fmt.Errorf("%s is not a valid gravity", l.Gravity)
+	}
+	if _, ok := filters[normalize(l.Filter)]; !ok {
+		return fmt.Errorf("%s is not a valid resampling filter (lanczos, catmull-rom, mitchell, linear, box, nearest)", l.Filter)
+	}
+	return nil
+}
+
+// apply resizes the image to the layout's box:
+//
+//	contain  scales the image to fit within the box, keeping its aspect ratio
+//	cover    scales the image to cover the box, and crops what overflows it
+//	fill     stretches the image to the box
+//	none     keeps the image's size, and crops what overflows the box
+func (l Layout) apply(img image.Image) (image.Image, error) {
+	if err := l.Validate(); err != nil {
+		return nil, err
+	}
+	b := img.Bounds()
+	if (l.Width == 0 && l.Height == 0) || b.Empty() {
+		return img, nil
+	}
+	filter := filters[normalize(l.Filter)]
+	gravity := gravities[normalize(l.Gravity)]
+	fit := normalize(l.Fit)
+
+	// A single dimension always scales the image, unless it is not to be
+	// resized at all.
+	if l.Width == 0 || l.Height == 0 {
+		if fit == "none" {
+			return imaging.CropAnchor(img, boxDim(l.Width, b.Dx()), boxDim(l.Height, b.Dy()), gravity), nil
+		}
+		return imaging.Resize(img, l.Width, l.Height, filter), nil
+	}
+
+	switch fit {
+	case "cover":
+		return imaging.Fill(img, l.Width, l.Height, gravity, filter), nil
+	case "fill":
+		return imaging.Resize(img, l.Width, l.Height, filter), nil
+	case "none":
+		return imaging.CropAnchor(img, boxDim(l.Width, b.Dx()), boxDim(l.Height, b.Dy()), gravity), nil
+	}
+
+	// contain: the image is scaled up or down until it touches the box.
+	if b.Dx()*l.Height > b.Dy()*l.Width {
+		return imaging.Resize(img, l.Width, 0, filter), nil
+	}
+	return imaging.Resize(img, 0, l.Height, filter), nil
+}
+
+// boxDim returns the size of the box in a dimension for an image of size
+// `n`, which is the image's size if the box does not constrain it.
+func boxDim(box, n int) int {
+	if box <= 0 || box > n {
+		return n
+	}
+	return box
+}
+
+////////////////////////////////////////////////////////////////////////////////
@@ -0,0 +1,171 @@
+package image
+
+////////////////////////////////////////////////////////////////////////////////
+
+import (
+	"fmt"
+	"image"
+	"image/color"
+	"math"
+	"os"
+
+	"github.com/disintegration/imaging"
+)
+
+////////////////////////////////////////////////////////////////////////////////
+
+// Mask shapes.
+const (
+	MaskCircle  = "circle"  // largest circle centered in the image
+	MaskEllipse = "ellipse" // ellipse inscribed in the image
+	MaskRounded = "rounded" // rectangle with rounded corners
+)
+
+// maskSamples is the number of samples across each pixel (in each dimension)
+// used to anti-alias the edges of the mask shapes.
+const maskSamples = 4
+
+// Mask specifies the shape that the image is cut to after it is resized.
+// Either a shape or the path of a mask image is set.
+type Mask struct {
+	Shape  string // circle, ellipse or rounded
+	Radius int    // corner radius of the rounded shape in pixels
+	Path   string // grayscale or alpha mask image, stretched to the image
+}
+
+// Validate returns an error if the mask is invalid.
+func (m *Mask) Validate() error {
+	if m == nil {
+		return nil
+	}
+	if len(m.Path) > 0 {
+		if len(m.Shape) > 0 {
+			return fmt.Errorf("mask must be either a shape or an image, not both")
+		}
+		return nil
+	}
+	switch normalize(m.Shape) {
+	case MaskCircle, MaskEllipse:
+	case MaskRounded:
+		if m.Radius <= 0 {
+			return fmt.Errorf("rounded mask requires a positive radius")
+		}
+	case "":
+		return fmt.Errorf("mask shape or image must be specified")
+	default:
+		return fmt.Errorf("%s is not a valid mask shape (circle, ellipse, rounded)", m.Shape)
+	}
+	if m.Radius < 0 {
+		return fmt.Errorf("mask radius must not be negative")
+	}
+	return nil
+}
+
+// apply returns a copy of the image whose alpha is multiplied by the mask's
+// coverage of each pixel.
+func (m *Mask) apply(img image.Image) (image.Image, error) {
+	if m == nil {
+		return img, nil
+	}
+	if err := m.Validate(); err != nil {
+		return nil, err
+	}
+
+	dst := imaging.Clone(img)
+	w, h := dst.Bounds().Dx(), dst.Bounds().Dy()
+	if w == 0 || h == 0 {
+		return dst, nil
+	}
+
+	var coverage func(x, y int) float64
+	if len(m.Path) > 0 {
+		mask, err := loadMask(m.Path, w, h)
+		if err != nil {
+			return nil, err
+		}
+		coverage = mask
+	} else {
+		inside := m.shape(w, h)
+		coverage = func(x, y int) float64 {
+			n := 0
+			for sy := 0; sy < maskSamples; sy++ {
+				for sx := 0; sx < maskSamples; sx++ {
+					px := float64(x) + (float64(sx)+0.5)/maskSamples
+					py := float64(y) + (float64(sy)+0.5)/maskSamples
+					if inside(px, py) {
+						n++
+					}
+				}
+			}
+			return float64(n) / (maskSamples * maskSamples)
+		}
+	}
+
+	for y := 0; y < h; y++ {
+		for x := 0; x < w; x++ {
+			i := dst.PixOffset(x, y)
+			dst.Pix[i+3] = uint8(float64(dst.Pix[i+3])*coverage(x, y) + 0.5)
+		}
+	}
+	return dst, nil
+}
+
+// shape returns a function that tests if a point of a `w` x `h` image is
+// inside the mask's shape.
+func (m *Mask) shape(w, h int) func(x, y float64) bool {
+	fw, fh := float64(w), float64(h)
+	switch normalize(m.Shape) {
+	case MaskCircle:
+		r := math.Min(fw, fh) / 2
+		return func(x, y float64) bool {
+			dx, dy := x-fw/2, y-fh/2
+			return dx*dx+dy*dy <= r*r
+		}
+	case MaskEllipse:
+		a, b := fw/2, fh/2
+		return func(x, y float64) bool {
+			dx, dy := (x-a)/a, (y-b)/b
+			return dx*dx+dy*dy <= 1
+		}
+	}
+
+	// Rounded: a point is inside unless it is in a corner square and outside
+	// the corner's quarter circle.
+	r := math.Min(float64(m.Radius), math.Min(fw, fh)/2)
+	return func(x, y float64) bool {
+		cx := math.Min(math.Max(x, r), fw-r)
+		cy := math.Min(math.Max(y, r), fh-r)
+		dx, dy := x-cx, y-cy
+		return dx*dx+dy*dy <= r*r
+	}
+}
+
+// loadMask reads the mask image at `fp` and stretches it to `w` x `h`.  The
+// coverage of a pixel is the mask's alpha if it has any transparency, and its
+// luminance otherwise.
+func loadMask(fp string, w, h int) (func(x, y int) float64, error) {
+	fd, err := os.Open(fp)
+	if err != nil {
+		return nil, err
+	}
+	defer fd.Close()
+	src, _, err := image.Decode(fd)
+	if err != nil {
+		return nil, fmt.Errorf("unable to decode mask %s: %s", fp, err.Error())
+	}
+
+	useAlpha := false
+	if o, ok := src.(interface{ Opaque() bool }); ok {
+		useAlpha = !o.Opaque()
+	}
+	mask := imaging.Resize(src, w, h, imaging.Lanczos)
+	return func(x, y int) float64 {
+		c := mask.NRGBAAt(x, y)
+		if useAlpha {
+			return float64(c.A) / 0xff
+		}
+		return float64(color.GrayModel.Convert(color.NRGBA{c.R, c.G, c.B, 0xff}).(color.Gray).Y) / 0xff
+	}, nil
+}
+
+////////////////////////////////////////////////////////////////////////////////
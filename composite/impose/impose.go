@@ -0,0 +1,230 @@
+package impose
+
+////////////////////////////////////////////////////////////////////////////////
+/*
+
+Package impose packs successive rendered items onto larger print sheets in a
+grid (N-up imposition), optionally with crop and registration marks.
+
+All dimensions are in pixels of the sheet, which is at the same resolution
+as the items placed on it.
+
+*/
+////////////////////////////////////////////////////////////////////////////////
+
+import (
+	"fmt"
+	"image"
+	"image/color"
+	"image/draw"
+	"math"
+)
+
+////////////////////////////////////////////////////////////////////////////////
+
+// Layout describes the sheet and the grid of cells that items are placed in.
+type Layout struct {
+	SheetWidth, SheetHeight int
+	Rows, Columns           int
+	GutterX, GutterY        int // space between adjacent cells
+	Margin                  int // minimum space between the cells and the sheet edge
+	Bleed                   int // part of each item that extends beyond its trim line
+
+	CropMarks         bool
+	RegistrationMarks bool
+	MarkLength        int // length of each crop mark
+	MarkOffset        int // gap between the trim corner and its crop marks
+	MarkWeight        int // line thickness of the marks
+}
+
+// Validate checks that the grid of cells of the specified size fits on the
+// sheet within the margins.
+func (l *Layout) Validate(cell image.Point) error {
+	if l.Rows <= 0 || l.Columns <= 0 {
+		return fmt.Errorf("imposition: rows and columns must be positive")
+	}
+	if 2*l.Bleed >= cell.X || 2*l.Bleed >= cell.Y {
+		return fmt.Errorf("imposition: bleed is larger than the item (%dx%d px)", cell.X, cell.Y)
+	}
+	gw, gh := l.gridSize(cell)
+	if gw > l.SheetWidth-2*l.Margin || gh > l.SheetHeight-2*l.Margin {
+		return fmt.Errorf("imposition: %dx%d grid of %dx%d px items does not fit on a %dx%d px sheet",
+			l.Columns, l.Rows, cell.X, cell.Y, l.SheetWidth, l.SheetHeight)
+	}
+	return nil
+}
+
+// PerSheet returns the number of items on each sheet.
+func (l *Layout) PerSheet() int {
+	return l.Rows * l.Columns
+}
+
+func (l *Layout) gridSize(cell image.Point) (int, int) {
+	return l.Columns*cell.X + (l.Columns-1)*l.GutterX, l.Rows*cell.Y + (l.Rows-1)*l.GutterY
+}
+
+// cellRect returns the bounds of the i'th cell (in row-major order) on the
+// sheet.  The grid is centered on the sheet.
+func (l *Layout) cellRect(cell image.Point, i int) image.Rectangle {
+	gw, gh := l.gridSize(cell)
+	x0 := (l.SheetWidth - gw) / 2
+	y0 := (l.SheetHeight - gh) / 2
+	r, c := i/l.Columns, i%l.Columns
+	min := image.Pt(x0+c*(cell.X+l.GutterX), y0+r*(cell.Y+l.GutterY))
+	return image.Rectangle{min, min.Add(cell)}
+}
+
+////////////////////////////////////////////////////////////////////////////////
+
+// Sheet is a single sheet that items are added to in order.
+type Sheet struct {
+	layout *Layout
+	cell   image.Point
+	img    *image.RGBA
+	count  int
+}
+
+// NewSheet returns an empty (white) sheet for items of the specified size.
+func NewSheet(l *Layout, cell image.Point) (*Sheet, error) {
+	if err := l.Validate(cell); err != nil {
+		return nil, err
+	}
+
+	s := &Sheet{
+		layout: l,
+		cell:   cell,
+		img:    image.NewRGBA(image.Rect(0, 0, l.SheetWidth, l.SheetHeight)),
+	}
+	draw.Draw(s.img, s.img.Bounds(), image.White, image.ZP, draw.Src)
+
+	// Marks are drawn before any items, so that the marks which fall inside
+	// the bleed of a neighbouring item are covered by it.
+	if l.CropMarks {
+		for i := 0; i < l.PerSheet(); i++ {
+			s.drawCropMarks(l.cellRect(cell, i).Inset(l.Bleed))
+		}
+	}
+	if l.RegistrationMarks {
+		s.drawRegistrationMarks()
+	}
+	return s, nil
+}
+
+// Add places the next item on the sheet.
+func (s *Sheet) Add(img image.Image) error {
+	if s.Full() {
+		return fmt.Errorf("imposition: sheet is full")
+	}
+	b := img.Bounds()
+	if b.Size() != s.cell {
+		return fmt.Errorf("imposition: item is %dx%d px, expected %dx%d px", b.Dx(), b.Dy(), s.cell.X, s.cell.Y)
+	}
+	draw.Draw(s.img, s.layout.cellRect(s.cell, s.count), img, b.Min, draw.Over)
+	s.count++
+	return nil
+}
+
+// Full returns true if there is no room for another item on the sheet.
+func (s *Sheet) Full() bool {
+	return s.count >= s.layout.PerSheet()
+}
+
+// Count returns the number of items on the sheet.
+func (s *Sheet) Count() int {
+	return s.count
+}
+
+// Image returns the sheet image.
+func (s *Sheet) Image() *image.RGBA {
+	return s.img
+}
+
+////////////////////////////////////////////////////////////////////////////////
+
+// drawCropMarks draws the horizontal and vertical marks at each corner of the
+// trim box, extending away from it.
+func (s *Sheet) drawCropMarks(trim image.Rectangle) {
+	l := s.layout
+	off, n, w := l.MarkOffset, l.MarkLength, l.markWeight()
+	corners := []struct {
+		pt     image.Point
+		dx, dy int
+	}{
+		{trim.Min, -1, -1},
+		{image.Pt(trim.Max.X, trim.Min.Y), 1, -1},
+		{image.Pt(trim.Min.X, trim.Max.Y), -1, 1},
+		{trim.Max, 1, 1},
+	}
+	for _, c := range corners {
+		// Horizontal mark on the line of the horizontal trim edge.
+		x0 := c.pt.X + c.dx*off
+		x1 := c.pt.X + c.dx*(off+n)
+		s.fill(image.Rect(x0, c.pt.Y-w/2, x1, c.pt.Y-w/2+w).Canon())
+
+		// Vertical mark on the line of the vertical trim edge.
+		y0 := c.pt.Y + c.dy*off
+		y1 := c.pt.Y + c.dy*(off+n)
+		s.fill(image.Rect(c.pt.X-w/2, y0, c.pt.X-w/2+w, y1).Canon())
+	}
+}
+
+// drawRegistrationMarks draws a target (a circle with a cross hair) centered
+// in the margin on each side of the sheet.
+func (s *Sheet) drawRegistrationMarks() {
+	l := s.layout
+	gw, gh := l.gridSize(s.cell)
+	r := l.MarkLength / 2
+	if r <= 0 {
+		return
+	}
+
+	// Centre the targets between the grid and the edge of the sheet.
+	mx := (l.SheetWidth - gw) / 4
+	my := (l.SheetHeight - gh) / 4
+	centers := []image.Point{
+		image.Pt(l.SheetWidth/2, my),
+		image.Pt(l.SheetWidth/2, l.SheetHeight-my),
+		image.Pt(mx, l.SheetHeight/2),
+		image.Pt(l.SheetWidth-mx, l.SheetHeight/2),
+	}
+	w := l.markWeight()
+	for _, c := range centers {
+		s.fill(image.Rect(c.X-r-w, c.Y-w/2, c.X+r+w, c.Y-w/2+w))
+		s.fill(image.Rect(c.X-w/2, c.Y-r-w, c.X-w/2+w, c.Y+r+w))
+		s.ring(c, float64(r)*0.6, float64(w))
+	}
+}
+
+func (l *Layout) markWeight() int {
+	if l.MarkWeight < 1 {
+		return 1
+	}
+	return l.MarkWeight
+}
+
+func (s *Sheet) fill(r image.Rectangle) {
+	draw.Draw(s.img, r, image.Black, image.ZP, draw.Src)
+}
+
+// ring draws an anti-aliased circle of radius `r` and line thickness `w`.
+func (s *Sheet) ring(c image.Point, r, w float64) {
+	ext := int(math.Ceil(r + w))
+	for y := -ext; y <= ext; y++ {
+		for x := -ext; x <= ext; x++ {
+			d := math.Abs(math.Hypot(float64(x), float64(y)) - r)
+			cov := math.Max(0, math.Min(1, w/2+0.5-d))
+			if cov <= 0 {
+				continue
+			}
+			px, py := c.X+x, c.Y+y
+			if !(image.Point{px, py}.In(s.img.Bounds())) {
+				continue
+			}
+			old := s.img.RGBAAt(px, py)
+			v := uint8(float64(old.R) * (1 - cov))
+			s.img.SetRGBA(px, py, color.RGBA{v, v, v, 0xff})
+		}
+	}
+}
+
+////////////////////////////////////////////////////////////////////////////////
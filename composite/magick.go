@@ -0,0 +1,233 @@
+package composite
+
+////////////////////////////////////////////////////////////////////////////////
+
+import (
+	"bytes"
+	"fmt"
+	"image"
+	"image/color"
+	"image/png"
+	"io"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path"
+	"path/filepath"
+	"strings"
+
+	"github.com/disintegration/imaging"
+)
+
+////////////////////////////////////////////////////////////////////////////////
+
+// magickCompose maps each blend mode to the equivalent ImageMagick operator.
+// ImageMagick's "In" and "Out" clear the whole image outside of the overlay,
+// unlike `Composite` which only touches the pixels that the overlay covers,
+// so they are not supported.
+var magickCompose = map[BlendMode]string{
+	BlendOver:     "Over",
+	BlendAtop:     "Atop",
+	BlendXor:      "Xor",
+	BlendMultiply: "Multiply",
+	BlendScreen:   "Screen",
+	BlendOverlay:  "Overlay",
+	BlendDarken:   "Darken",
+	BlendLighten:  "Lighten",
+}
+
+// magickColorspace maps each output colorspace to the ImageMagick colorspace.
+var magickColorspace = map[string]string{
+	"rgb":  "sRGB",
+	"rgba": "sRGB",
+	"cmyk": "CMYK",
+}
+
+var magickCapabilities = &Capabilities{
+	Formats:     []string{"jpeg", "jpg", "png", "tiff", "tif", "pdf", "gif", "bmp", "webp"},
+	ColorSpaces: []string{"rgba", "cmyk"},
+	CMYKFormats: []string{"jpeg", "jpg", "tiff", "tif", "pdf"},
+	BlendModes: []BlendMode{
+		BlendOver, BlendAtop, BlendXor,
+		BlendMultiply, BlendScreen, BlendOverlay, BlendDarken, BlendLighten,
+	},
+}
+
+// magickBackend composites images with ImageMagick's `convert`.
+type magickBackend struct {
+	convert string // path to the convert binary
+}
+
+// newMagickBackend finds the convert binary in the configured directory, or
+// on the $PATH if none is configured.
+func newMagickBackend(opts BackendOptions) (Backend, error) {
+	bin := "convert"
+	if len(opts.MagickBins) > 0 {
+		bin = path.Join(opts.MagickBins, "convert")
+	}
+	convert, err := exec.LookPath(bin)
+	if err != nil {
+		return nil, fmt.Errorf("imagemagick is not correctly installed, convert utility missing: %s", err.Error())
+	}
+	return &magickBackend{convert: convert}, nil
+}
+
+func (b *magickBackend) Name() string {
+	return "imagemagick"
+}
+
+func (b *magickBackend) Capabilities() *Capabilities {
+	return magickCapabilities
+}
+
+// Build composites the renderables onto the background with ImageMagick, and
+// writes the result in the job's format and colorspace.  Each overlay is
+// rendered to a png in a temporary directory, and all of them are composited
+// by a single invocation.  The file is only replaced once it has been written
+// in full.
+//
+// ImageMagick looks for a format ("png:") and a frame selector ("[0]") in the
+// names of files, which the paths of items may well contain.  So it is only
+// given fixed names in the temporary directory, each with an explicit format,
+// and reads the background from stdin.
+func (b *magickBackend) Build(job *Job) error {
+	cs, ok := magickColorspace[strings.ToLower(job.ColorSpace)]
+	if !ok {
+		return fmt.Errorf("%s is not a valid colorspace", job.ColorSpace)
+	}
+	bgpath, ofpath, offmt := job.Background, job.Path, job.Format
+
+	tmpdir, err := ioutil.TempDir("", "imagenie-magick-")
+	if err != nil {
+		return err
+	}
+	defer os.RemoveAll(tmpdir)
+
+	// A filtered background is rendered to a png to be composited onto.
+	if len(job.Filters) > 0 {
+		bg, err := LoadBackground(bgpath, job.Filters)
+		if err != nil {
+			return err
+		}
+		bgpath = filepath.Join(tmpdir, "background.png")
+		if err := writePNG(bgpath, bg); err != nil {
+			return err
+		}
+	}
+
+	// Read the size of the background so that overlays can be placed.
+	bgFd, err := os.Open(bgpath)
+	if err != nil {
+		return err
+	}
+	defer bgFd.Close()
+	bgCfg, bgfmt, err := image.DecodeConfig(bgFd)
+	if err != nil {
+		return err
+	}
+	if _, err := bgFd.Seek(0, io.SeekStart); err != nil {
+		return err
+	}
+	bounds := image.Rect(0, 0, bgCfg.Width, bgCfg.Height)
+
+	// Each overlay is composited onto the result so far, in order.
+	args := []string{bgfmt + ":-"}
+	for idx, item := range job.Items {
+		primg, rot, xoff, yoff, err := item.Render()
+		if err != nil {
+			return err
+		}
+		img := primg
+		if rot > 0 && rot < 360 {
+			img = imaging.Rotate(primg, float64(rot), color.Transparent)
+		}
+
+		mode, opacity := blendOf(item)
+		if opacity < 1 {
+			img = applyOpacity(img, opacity)
+		}
+
+		name := fmt.Sprintf("overlay_%03d.png", idx)
+		if err := writePNG(filepath.Join(tmpdir, name), img); err != nil {
+			return err
+		}
+
+		pt := placeOf(item, bounds, img, xoff, yoff)
+		args = append(args,
+			magickFile("png", name),
+			"-geometry", fmt.Sprintf("%+d%+d", pt.X, pt.Y),
+			"-compose", magickCompose[mode],
+			"-composite")
+	}
+
+	// The image is written to the temporary directory, and only copied into
+	// place once it is complete.
+	args = append(args, "-colorspace", cs, magickFile(magickFormat(offmt), "output"))
+
+	var stderr bytes.Buffer
+	cmd := exec.Command(b.convert, args...)
+	cmd.Dir = tmpdir
+	cmd.Stdin = bgFd
+	cmd.Stderr = &stderr
+	if err := cmd.Run(); err != nil {
+		if msg := strings.TrimSpace(stderr.String()); len(msg) > 0 {
+			return fmt.Errorf("convert failed: %s: %s", err.Error(), msg)
+		}
+		return fmt.Errorf("convert failed: %s", err.Error())
+	}
+	return copyAtomic(filepath.Join(tmpdir, "output"), ofpath)
+}
+
+////////////////////////////////////////////////////////////////////////////////
+
+// magickFile returns the argument for the file `name` in the working directory
+// of convert, with an explicit format so that the name is used as is.
+func magickFile(format, name string) string {
+	return format + ":./" + name
+}
+
+// magickFormat returns ImageMagick's name for the output format.
+func magickFormat(offmt string) string {
+	switch f := strings.ToLower(offmt); f {
+	case "jpg":
+		return "jpeg"
+	case "tif":
+		return "tiff"
+	default:
+		return f
+	}
+}
+
+// copyAtomic copies the file at `src` to `dst`, which is only replaced once the
+// copy is complete.
+func copyAtomic(src, dst string) error {
+	fd, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer fd.Close()
+	out, err := CreateAtomic(dst)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(out, fd); err != nil {
+		out.Abort()
+		return err
+	}
+	return out.Commit()
+}
+
+// writePNG writes the image to a new png file at `p`.
+func writePNG(p string, img image.Image) error {
+	fd, err := os.Create(p)
+	if err != nil {
+		return err
+	}
+	if err := png.Encode(fd, img); err != nil {
+		fd.Close()
+		return err
+	}
+	return fd.Close()
+}
+
+////////////////////////////////////////////////////////////////////////////////
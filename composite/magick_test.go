@@ -0,0 +1,200 @@
+package composite
+
+////////////////////////////////////////////////////////////////////////////////
+
+import (
+	"bytes"
+	"image"
+	"image/color"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+////////////////////////////////////////////////////////////////////////////////
+
+// testRenderable renders a fixed image.
+type testRenderable struct {
+	img image.Image
+}
+
+func (r testRenderable) Render() (image.Image, int, int, int, error) {
+	return r.img, 0, 0, 0, nil
+}
+
+// testImages returns an opaque background, and an overlay whose alpha ranges
+// from transparent to opaque.
+func testImages() (*image.RGBA, *image.NRGBA) {
+	bg := image.NewRGBA(image.Rect(0, 0, 64, 48))
+	for y := 0; y < 48; y++ {
+		for x := 0; x < 64; x++ {
+			bg.SetRGBA(x, y, color.RGBA{uint8(4 * x), uint8(5 * y), 128, 255})
+		}
+	}
+	fg := image.NewNRGBA(image.Rect(0, 0, 20, 16))
+	for y := 0; y < 16; y++ {
+		for x := 0; x < 20; x++ {
+			fg.SetNRGBA(x, y, color.NRGBA{uint8(12 * x), 200, uint8(16 * y), uint8(255 * x / 19)})
+		}
+	}
+	return bg, fg
+}
+
+////////////////////////////////////////////////////////////////////////////////
+
+func TestMagickBlendModes(t *testing.T) {
+	for _, m := range magickCapabilities.BlendModes {
+		if _, ok := magickCompose[m]; !ok {
+			t.Errorf("%s is supported, but has no imagemagick operator", m)
+		}
+	}
+	for _, m := range []BlendMode{BlendIn, BlendOut} {
+		if magickCapabilities.HasBlendMode(m) {
+			t.Errorf("%s is supported, but clears the image outside of the overlay", m)
+		}
+	}
+}
+
+// The backends render the same image for each of the blend modes that they
+// both support.
+func TestBackendsMatch(t *testing.T) {
+	magick, err := NewBackend("imagemagick", BackendOptions{})
+	if err != nil {
+		t.Skipf("imagemagick is not available: %s", err.Error())
+	}
+	native, err := NewBackend("native", BackendOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	dir, err := ioutil.TempDir("", "imagenie-backends-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	bg, fg := testImages()
+	bgpath := filepath.Join(dir, "bg.png")
+	if err := writePNG(bgpath, bg); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, mode := range magickCapabilities.BlendModes {
+		for _, opacity := range []float64{1, 0.5} {
+			imgs := []*image.RGBA{}
+			for _, b := range []Backend{native, magick} {
+				job := &Job{
+					Background: bgpath,
+					Items: []Renderable{
+						NewLayer(testRenderable{fg}, Placement{X: Pixels(10), Y: Pixels(8)}, mode, opacity),
+					},
+					Path:       filepath.Join(dir, b.Name()+".png"),
+					Format:     "png",
+					ColorSpace: "rgba",
+				}
+				if err := b.Build(job); err != nil {
+					t.Fatalf("%s: %s", b.Name(), err.Error())
+				}
+				img, err := LoadImage(job.Path)
+				if err != nil {
+					t.Fatal(err)
+				}
+				rgba := image.NewRGBA(img.Bounds())
+				for y := 0; y < 48; y++ {
+					for x := 0; x < 64; x++ {
+						rgba.Set(x, y, img.At(x, y))
+					}
+				}
+				imgs = append(imgs, rgba)
+			}
+
+			const tolerance = 3
+			for i := range imgs[0].Pix {
+				if d := int(imgs[0].Pix[i]) - int(imgs[1].Pix[i]); d < -tolerance || d > tolerance {
+					x, y := (i/4)%64, (i/4)/64
+					t.Errorf("%s at %v: channel %d of (%d, %d) is %d natively, and %d with imagemagick",
+						mode, opacity, i%4, x, y, imgs[0].Pix[i], imgs[1].Pix[i])
+					break
+				}
+			}
+		}
+	}
+}
+
+////////////////////////////////////////////////////////////////////////////////
+
+// The paths of the background and output are never parsed by imagemagick,
+// whatever characters they contain.
+func TestMagickPaths(t *testing.T) {
+	dir, err := ioutil.TempDir("", "imagenie-magick-paths-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	// The fake convert records its arguments and stdin, and writes a fixed
+	// image to the file named by its last argument.
+	bg, fg := testImages()
+	result := filepath.Join(dir, "result.png")
+	if err := writePNG(result, fg); err != nil {
+		t.Fatal(err)
+	}
+	script := "#!/bin/sh\n" +
+		"for a; do echo \"$a\" >> " + filepath.Join(dir, "args") + "; last=$a; done\n" +
+		"cat > " + filepath.Join(dir, "stdin") + "\n" +
+		"cp " + result + " \"${last#*:}\"\n"
+	if err := ioutil.WriteFile(filepath.Join(dir, "convert"), []byte(script), 0755); err != nil {
+		t.Fatal(err)
+	}
+	b, err := NewBackend("imagemagick", BackendOptions{MagickBins: dir})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	odd := filepath.Join(dir, "-png:[0]")
+	if err := os.Mkdir(odd, 0777); err != nil {
+		t.Fatal(err)
+	}
+	bgpath := filepath.Join(odd, "-bg[0].png")
+	if err := writePNG(bgpath, bg); err != nil {
+		t.Fatal(err)
+	}
+	job := &Job{
+		Background: bgpath,
+		Items: []Renderable{
+			NewLayer(testRenderable{fg}, Placement{}, BlendOver, 1),
+		},
+		Path:       filepath.Join(odd, "jpg:out[1].png"),
+		Format:     "png",
+		ColorSpace: "rgba",
+	}
+	if err := b.Build(job); err != nil {
+		t.Fatal(err)
+	}
+
+	args, err := ioutil.ReadFile(filepath.Join(dir, "args"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := []string{
+		"png:-",
+		"png:./overlay_000.png", "-geometry", "+0+0", "-compose", "Over", "-composite",
+		"-colorspace", "sRGB", "png:./output",
+	}
+	if got := strings.Split(strings.TrimSpace(string(args)), "\n"); strings.Join(got, " ") != strings.Join(expected, " ") {
+		t.Errorf("convert was run with %q, expected %q", got, expected)
+	}
+	for _, c := range []struct{ got, expected string }{
+		{filepath.Join(dir, "stdin"), bgpath},
+		{job.Path, result},
+	} {
+		got, _ := ioutil.ReadFile(c.got)
+		expected, _ := ioutil.ReadFile(c.expected)
+		if len(got) == 0 || !bytes.Equal(got, expected) {
+			t.Errorf("%s does not match %s", c.got, c.expected)
+		}
+	}
+}
+
+////////////////////////////////////////////////////////////////////////////////
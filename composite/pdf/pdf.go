@@ -0,0 +1,235 @@
+package pdf
+
+////////////////////////////////////////////////////////////////////////////////
+/*
+
+Package pdf implements a minimal streaming PDF writer for documents where each
+page is a single full-bleed raster image.
+
+Pages are encoded (and compressed) independently of the document so that they
+can be prepared concurrently, and are then appended to the document in order.
+
+*/
+////////////////////////////////////////////////////////////////////////////////
+
+import (
+	"bytes"
+	"compress/zlib"
+	"fmt"
+	"image"
+	"io"
+	"strconv"
+	"strings"
+)
+
+////////////////////////////////////////////////////////////////////////////////
+
+const (
+	catalogObj = 1
+	pagesObj   = 2
+)
+
+////////////////////////////////////////////////////////////////////////////////
+
+// Page is an image that has been encoded and is ready to be added to a
+// document.
+type Page struct {
+	width, height int     // in pixels
+	dpi           float64 // pixels per inch, used for the page size
+	data          []byte  // zlib compressed RGB samples
+}
+
+// NewPage encodes the image as a page which is sized so that the image is
+// printed at `dpi` pixels per inch.  Transparent pixels are composited onto
+// white.
+func NewPage(img image.Image, dpi float64) (*Page, error) {
+	if dpi <= 0 {
+		return nil, fmt.Errorf("pdf: invalid dpi %v", dpi)
+	}
+
+	b := img.Bounds()
+	var buf bytes.Buffer
+	zw := zlib.NewWriter(&buf)
+	row := make([]byte, 3*b.Dx())
+	for y := b.Min.Y; y < b.Max.Y; y++ {
+		switch src := img.(type) {
+		case *image.RGBA:
+			rgbaRow(row, src, y)
+		case *image.CMYK:
+			cmykRow(row, src, y)
+		default:
+			for i, x := 0, b.Min.X; x < b.Max.X; i, x = i+3, x+1 {
+				r, g, bb, a := img.At(x, y).RGBA()
+				row[i+0] = uint8((r + 0xffff - a) >> 8)
+				row[i+1] = uint8((g + 0xffff - a) >> 8)
+				row[i+2] = uint8((bb + 0xffff - a) >> 8)
+			}
+		}
+		if _, err := zw.Write(row); err != nil {
+			return nil, err
+		}
+	}
+	if err := zw.Close(); err != nil {
+		return nil, err
+	}
+
+	return &Page{
+		width:  b.Dx(),
+		height: b.Dy(),
+		dpi:    dpi,
+		data:   buf.Bytes(),
+	}, nil
+}
+
+// rgbaRow composites row `y` of the (premultiplied) image onto white, which
+// is the same as going through `At`, without converting each pixel.
+func rgbaRow(row []byte, img *image.RGBA, y int) {
+	pix := img.Pix[img.PixOffset(img.Rect.Min.X, y):]
+	for i, j := 0, 0; i < len(row); i, j = i+3, j+4 {
+		a := 0xff - pix[j+3]
+		row[i+0] = pix[j+0] + a
+		row[i+1] = pix[j+1] + a
+		row[i+2] = pix[j+2] + a
+	}
+}
+
+// cmykRow converts row `y` of the image to RGB as `color.CMYK` does.
+func cmykRow(row []byte, img *image.CMYK, y int) {
+	pix := img.Pix[img.PixOffset(img.Rect.Min.X, y):]
+	for i, j := 0, 0; i < len(row); i, j = i+3, j+4 {
+		w := 0xffff - uint32(pix[j+3])*0x101
+		row[i+0] = uint8((0xffff - uint32(pix[j+0])*0x101) * w / 0xffff >> 8)
+		row[i+1] = uint8((0xffff - uint32(pix[j+1])*0x101) * w / 0xffff >> 8)
+		row[i+2] = uint8((0xffff - uint32(pix[j+2])*0x101) * w / 0xffff >> 8)
+	}
+}
+
+// size returns the page size in points.
+func (p *Page) size() (float64, float64) {
+	return float64(p.width) * 72.0 / p.dpi, float64(p.height) * 72.0 / p.dpi
+}
+
+////////////////////////////////////////////////////////////////////////////////
+
+// Writer streams pages into a PDF document.  Close must be called to write
+// the trailer which makes the document valid.
+type Writer struct {
+	w       io.Writer
+	n       int64   // bytes written so far
+	offsets []int64 // offset of each object, indexed by object number - 1
+	pages   []int   // object number of each page
+	err     error
+}
+
+// NewWriter writes the header and catalog of a new document to `w`.
+func NewWriter(w io.Writer) (*Writer, error) {
+	pw := &Writer{
+		w:       w,
+		offsets: make([]int64, pagesObj),
+	}
+	pw.printf("%%PDF-1.4\n%%\xe2\xe3\xcf\xd3\n")
+	pw.startObj(catalogObj)
+	pw.printf("<< /Type /Catalog /Pages %d 0 R >>\nendobj\n", pagesObj)
+	return pw, pw.err
+}
+
+// AddPage appends the page to the document.
+func (pw *Writer) AddPage(p *Page) error {
+	w, h := p.size()
+	ws, hs := fnum(w), fnum(h)
+
+	img := pw.newObj()
+	pw.printf("<< /Type /XObject /Subtype /Image /Width %d /Height %d /ColorSpace /DeviceRGB /BitsPerComponent 8 /Filter /FlateDecode /Length %d >>\nstream\n", p.width, p.height, len(p.data))
+	pw.write(p.data)
+	pw.printf("\nendstream\nendobj\n")
+
+	content := fmt.Sprintf("q %s 0 0 %s 0 0 cm /Im0 Do Q", ws, hs)
+	contentObj := pw.newObj()
+	pw.printf("<< /Length %d >>\nstream\n%s\nendstream\nendobj\n", len(content), content)
+
+	page := pw.newObj()
+	pw.printf("<< /Type /Page /Parent %d 0 R /MediaBox [0 0 %s %s] /Resources << /XObject << /Im0 %d 0 R >> >> /Contents %d 0 R >>\nendobj\n", pagesObj, ws, hs, img, contentObj)
+	pw.pages = append(pw.pages, page)
+	return pw.err
+}
+
+// Close writes the page tree, cross-reference table and trailer.  It does not
+// close the underlying writer.
+func (pw *Writer) Close() error {
+	pw.startObj(pagesObj)
+	pw.printf("<< /Type /Pages /Count %d /Kids [", len(pw.pages))
+	for _, p := range pw.pages {
+		pw.printf(" %d 0 R", p)
+	}
+	pw.printf(" ] >>\nendobj\n")
+
+	xref := pw.n
+	pw.printf("xref\n0 %d\n0000000000 65535 f \n", len(pw.offsets)+1)
+	for _, off := range pw.offsets {
+		pw.printf("%010d 00000 n \n", off)
+	}
+	pw.printf("trailer\n<< /Size %d /Root %d 0 R >>\nstartxref\n%d\n%%%%EOF\n", len(pw.offsets)+1, catalogObj, xref)
+	return pw.err
+}
+
+// Pages returns the number of pages added so far.
+func (pw *Writer) Pages() int {
+	return len(pw.pages)
+}
+
+////////////////////////////////////////////////////////////////////////////////
+
+// Encode writes a single page document containing the image.
+func Encode(w io.Writer, img image.Image, dpi float64) error {
+	p, err := NewPage(img, dpi)
+	if err != nil {
+		return err
+	}
+	pw, err := NewWriter(w)
+	if err != nil {
+		return err
+	}
+	if err := pw.AddPage(p); err != nil {
+		return err
+	}
+	return pw.Close()
+}
+
+////////////////////////////////////////////////////////////////////////////////
+
+// newObj allocates the next object number and starts the object.
+func (pw *Writer) newObj() int {
+	pw.offsets = append(pw.offsets, 0)
+	id := len(pw.offsets)
+	pw.startObj(id)
+	return id
+}
+
+// startObj records the offset of a (pre-allocated) object and starts it.
+func (pw *Writer) startObj(id int) {
+	pw.offsets[id-1] = pw.n
+	pw.printf("%d 0 obj\n", id)
+}
+
+func (pw *Writer) printf(format string, args ...interface{}) {
+	pw.write([]byte(fmt.Sprintf(format, args...)))
+}
+
+func (pw *Writer) write(b []byte) {
+	if pw.err != nil {
+		return
+	}
+	n, err := pw.w.Write(b)
+	pw.n += int64(n)
+	pw.err = err
+}
+
+// fnum formats a number of points to a thousandth of a point, without any
+// trailing zeros.
+func fnum(v float64) string {
+	s := strconv.FormatFloat(v, 'f', 3, 64)
+	s = strings.TrimRight(s, "0")
+	return strings.TrimSuffix(s, ".")
+}
+
+////////////////////////////////////////////////////////////////////////////////
@@ -0,0 +1,170 @@
+package pdf
+
+////////////////////////////////////////////////////////////////////////////////
+
+import (
+	"bytes"
+	"compress/zlib"
+	"image"
+	"image/color"
+	"io/ioutil"
+	"regexp"
+	"strconv"
+	"testing"
+)
+
+////////////////////////////////////////////////////////////////////////////////
+
+// generic hides the concrete type of an image, so that pages are encoded
+// through `At`.
+type generic struct {
+	image.Image
+}
+
+func testRGBA(w, h int) *image.RGBA {
+	img := image.NewRGBA(image.Rect(3, 5, 3+w, 5+h))
+	b := img.Bounds()
+	for y := b.Min.Y; y < b.Max.Y; y++ {
+		for x := b.Min.X; x < b.Max.X; x++ {
+			a := uint8(255 * (x - b.Min.X) / w)
+			img.SetRGBA(x, y, color.RGBA{a / 2, a / 3, uint8(y) % (a + 1), a})
+		}
+	}
+	return img
+}
+
+func testCMYK(w, h int) *image.CMYK {
+	img := image.NewCMYK(image.Rect(0, 0, w, h))
+	for i := range img.Pix {
+		img.Pix[i] = uint8(i * 37)
+	}
+	return img
+}
+
+// pageSamples returns the decompressed samples of the page.
+func pageSamples(t *testing.T, p *Page) []byte {
+	zr, err := zlib.NewReader(bytes.NewReader(p.data))
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, err := ioutil.ReadAll(zr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return data
+}
+
+////////////////////////////////////////////////////////////////////////////////
+
+func TestNewPageFastPaths(t *testing.T) {
+	for name, img := range map[string]image.Image{
+		"rgba":     testRGBA(17, 9),
+		"sub rgba": testRGBA(17, 9).SubImage(image.Rect(6, 7, 15, 12)),
+		"cmyk":     testCMYK(11, 4),
+		"sub cmyk": testCMYK(11, 4).SubImage(image.Rect(2, 1, 9, 3)),
+	} {
+		fast, err := NewPage(img, 300)
+		if err != nil {
+			t.Fatal(err)
+		}
+		slow, err := NewPage(generic{img}, 300)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if fast.width != slow.width || fast.height != slow.height {
+			t.Errorf("%s: page is %dx%d, expected %dx%d", name, fast.width, fast.height, slow.width, slow.height)
+		}
+		if !bytes.Equal(pageSamples(t, fast), pageSamples(t, slow)) {
+			t.Errorf("%s: samples differ from those through At", name)
+		}
+	}
+}
+
+func TestNewPageTransparent(t *testing.T) {
+	img := image.NewRGBA(image.Rect(0, 0, 2, 1))
+	img.SetRGBA(1, 0, color.RGBA{0, 0, 0x80, 0x80})
+	p, err := NewPage(img, 72)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []byte{0xff, 0xff, 0xff, 0x7f, 0x7f, 0xff}
+	if got := pageSamples(t, p); !bytes.Equal(got, want) {
+		t.Errorf("samples are % x, expected % x", got, want)
+	}
+}
+
+func TestWriterStructure(t *testing.T) {
+	var buf bytes.Buffer
+	pw, err := NewWriter(&buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, size := range []image.Point{{30, 20}, {72, 144}, {5, 5}} {
+		p, err := NewPage(image.NewRGBA(image.Rectangle{Max: size}), 72)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := pw.AddPage(p); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := pw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if pw.Pages() != 3 {
+		t.Errorf("writer has %d pages, expected 3", pw.Pages())
+	}
+	data := buf.Bytes()
+
+	// The startxref offset points at the cross-reference table, and each of
+	// its entries at the object with that number.
+	m := regexp.MustCompile(`startxref\n(\d+)\n%%EOF\n$`).FindSubmatch(data)
+	if m == nil {
+		t.Fatalf("missing startxref")
+	}
+	xref, _ := strconv.Atoi(string(m[1]))
+	if !bytes.HasPrefix(data[xref:], []byte("xref\n")) {
+		t.Fatalf("startxref %d does not point at the xref table", xref)
+	}
+	m = regexp.MustCompile(`^xref\n0 (\d+)\n`).FindSubmatch(data[xref:])
+	if m == nil {
+		t.Fatalf("invalid xref table")
+	}
+	size, _ := strconv.Atoi(string(m[1]))
+	if size != 2+3*3+1 {
+		t.Errorf("xref has %d entries, expected %d", size, 2+3*3+1)
+	}
+	entries := regexp.MustCompile(`(\d{10}) (\d{5}) ([fn]) \n`).FindAllSubmatch(data[xref:], -1)
+	if len(entries) != size {
+		t.Fatalf("xref lists %d entries, expected %d", len(entries), size)
+	}
+	for id, e := range entries[1:] {
+		off, _ := strconv.Atoi(string(e[1]))
+		obj := []byte(strconv.Itoa(id+1) + " 0 obj\n")
+		if string(e[3]) != "n" || !bytes.HasPrefix(data[off:], obj) {
+			t.Errorf("xref entry %d at %d does not point at its object", id+1, off)
+		}
+	}
+	if !bytes.Contains(data, []byte("/Size "+strconv.Itoa(size)+" ")) {
+		t.Errorf("trailer size does not match the xref table")
+	}
+
+	// The page tree lists each page, with its size in points.
+	if n := len(regexp.MustCompile(`/Type /Page /Parent`).FindAll(data, -1)); n != 3 {
+		t.Errorf("found %d pages, expected 3", n)
+	}
+	if !regexp.MustCompile(`/Type /Pages /Count 3 /Kids \[ \d+ 0 R \d+ 0 R \d+ 0 R \]`).Match(data) {
+		t.Errorf("page tree does not list 3 pages")
+	}
+	if !bytes.Contains(data, []byte("/MediaBox [0 0 72 144]")) {
+		t.Errorf("missing the media box of the 72x144 page")
+	}
+}
+
+func TestNewPageInvalidDpi(t *testing.T) {
+	if _, err := NewPage(image.NewRGBA(image.Rect(0, 0, 1, 1)), 0); err == nil {
+		t.Errorf("expected an error for 0 dpi")
+	}
+}
+
+////////////////////////////////////////////////////////////////////////////////
@@ -0,0 +1,175 @@
+package composite
+
+////////////////////////////////////////////////////////////////////////////////
+
+import (
+	"fmt"
+	"image"
+	"math"
+	"strconv"
+	"strings"
+)
+
+////////////////////////////////////////////////////////////////////////////////
+
+// Anchor is the point of an overlay that is placed at its offset.
+type Anchor int
+
+const (
+	AnchorTopLeft Anchor = iota
+	AnchorTop
+	AnchorTopRight
+	AnchorLeft
+	AnchorCenter
+	AnchorRight
+	AnchorBottomLeft
+	AnchorBottom
+	AnchorBottomRight
+)
+
+var anchorNames = map[Anchor]string{
+	AnchorTopLeft:     "top-left",
+	AnchorTop:         "top",
+	AnchorTopRight:    "top-right",
+	AnchorLeft:        "left",
+	AnchorCenter:      "center",
+	AnchorRight:       "right",
+	AnchorBottomLeft:  "bottom-left",
+	AnchorBottom:      "bottom",
+	AnchorBottomRight: "bottom-right",
+}
+
+func (a Anchor) String() string {
+	if s, ok := anchorNames[a]; ok {
+		return s
+	}
+	return fmt.Sprintf("Anchor(%d)", int(a))
+}
+
+// ParseAnchor returns the anchor for the specified name.  An empty name maps
+// to the default "top-left" anchor.
+func ParseAnchor(s string) (Anchor, error) {
+	s = strings.Replace(strings.ToLower(s), "_", "-", -1)
+	switch s {
+	case "":
+		return AnchorTopLeft, nil
+	case "middle":
+		return AnchorCenter, nil
+	}
+	for a, name := range anchorNames {
+		if name == s {
+			return a, nil
+		}
+	}
+	return AnchorTopLeft, fmt.Errorf("%s is not a valid anchor", s)
+}
+
+// fractions returns the horizontal and vertical position of the anchor as a
+// fraction of the overlay's width and height.
+func (a Anchor) fractions() (float64, float64) {
+	return float64(int(a)%3) / 2, float64(int(a)/3) / 2
+}
+
+////////////////////////////////////////////////////////////////////////////////
+
+// Offset is a distance from the left (or top) edge of the background, either
+// in pixels or as a percentage of the background's width (or height).  A
+// negative offset is measured from the right (or bottom) edge instead.
+type Offset struct {
+	Value   float64
+	Percent bool
+}
+
+// Pixels returns an absolute offset of `v` pixels.
+func Pixels(v int) Offset {
+	return Offset{Value: float64(v)}
+}
+
+// ParseOffset parses a number of pixels ("40", "-10") or a percentage ("50%").
+func ParseOffset(s string) (Offset, error) {
+	s = strings.TrimSpace(s)
+	if len(s) == 0 {
+		return Offset{}, nil
+	}
+
+	o := Offset{}
+	if strings.HasSuffix(s, "%") {
+		o.Percent = true
+		s = strings.TrimSpace(strings.TrimSuffix(s, "%"))
+	}
+	v, err := strconv.ParseFloat(s, 64)
+	if err != nil {
+		return Offset{}, fmt.Errorf("%s is not a valid offset", s)
+	}
+	o.Value = v
+	return o, nil
+}
+
+// UnmarshalYAML allows offsets to be specified as plain numbers or as
+// percentage strings in the config.
+func (o *Offset) UnmarshalYAML(unmarshal func(interface{}) error) error {
+	var s string
+	if err := unmarshal(&s); err != nil {
+		return err
+	}
+	v, err := ParseOffset(s)
+	if err != nil {
+		return err
+	}
+	*o = v
+	return nil
+}
+
+func (o Offset) String() string {
+	if o.Percent {
+		return strconv.FormatFloat(o.Value, 'f', -1, 64) + "%"
+	}
+	return strconv.FormatFloat(o.Value, 'f', -1, 64)
+}
+
+// Int returns the offset as a whole number of pixels, ignoring percentages.
+func (o Offset) Int() int {
+	if o.Percent {
+		return 0
+	}
+	return int(o.Value)
+}
+
+// resolve returns the offset in pixels along an edge of the specified length.
+func (o Offset) resolve(length int) float64 {
+	v := o.Value
+	if o.Percent {
+		v = float64(length) * v / 100
+	}
+	if v < 0 || (v == 0 && math.Signbit(v)) {
+		v += float64(length)
+	}
+	return v
+}
+
+////////////////////////////////////////////////////////////////////////////////
+
+// Placement positions an overlay on the background.  The `Anchor` point of the
+// rendered overlay is placed at (`X`, `Y`).
+type Placement struct {
+	X, Y   Offset
+	Anchor Anchor
+}
+
+// Resolve returns the top-left corner of an overlay of the specified size on
+// the background.
+func (p Placement) Resolve(bg image.Rectangle, size image.Point) image.Point {
+	fx, fy := p.Anchor.fractions()
+	x := p.X.resolve(bg.Dx()) - fx*float64(size.X)
+	y := p.Y.resolve(bg.Dy()) - fy*float64(size.Y)
+	return bg.Min.Add(image.Pt(int(math.Floor(x+0.5)), int(math.Floor(y+0.5))))
+}
+
+// Placer is implemented by renderables that are positioned relative to the
+// background and their rendered size.  Renderables that do not implement it
+// are placed with their top-left corner at the offset returned by Render.
+type Placer interface {
+	Place(bg image.Rectangle, size image.Point) image.Point
+}
+
+////////////////////////////////////////////////////////////////////////////////
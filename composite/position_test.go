@@ -0,0 +1,136 @@
+package composite
+
+////////////////////////////////////////////////////////////////////////////////
+
+import (
+	"image"
+	"math"
+	"testing"
+)
+
+////////////////////////////////////////////////////////////////////////////////
+
+func TestParseAnchor(t *testing.T) {
+	for _, c := range []struct {
+		in       string
+		expected Anchor
+		fx, fy   float64
+	}{
+		{"", AnchorTopLeft, 0, 0},
+		{"top-left", AnchorTopLeft, 0, 0},
+		{"top", AnchorTop, 0.5, 0},
+		{"Top_Right", AnchorTopRight, 1, 0},
+		{"left", AnchorLeft, 0, 0.5},
+		{"center", AnchorCenter, 0.5, 0.5},
+		{"middle", AnchorCenter, 0.5, 0.5},
+		{"right", AnchorRight, 1, 0.5},
+		{"bottom-left", AnchorBottomLeft, 0, 1},
+		{"BOTTOM", AnchorBottom, 0.5, 1},
+		{"bottom_right", AnchorBottomRight, 1, 1},
+	} {
+		a, err := ParseAnchor(c.in)
+		if err != nil || a != c.expected {
+			t.Errorf("%q: expected %s, got %s, %v", c.in, c.expected, a, err)
+			continue
+		}
+		if fx, fy := a.fractions(); fx != c.fx || fy != c.fy {
+			t.Errorf("%s: expected fractions %v, %v, got %v, %v", a, c.fx, c.fy, fx, fy)
+		}
+	}
+	for _, in := range []string{"centre", "top left", "-"} {
+		if _, err := ParseAnchor(in); err == nil {
+			t.Errorf("%q: expected an error", in)
+		}
+	}
+}
+
+func TestParseOffset(t *testing.T) {
+	for _, c := range []struct {
+		in       string
+		expected Offset
+		str      string
+	}{
+		{"", Offset{}, "0"},
+		{"40", Offset{Value: 40}, "40"},
+		{" 40 ", Offset{Value: 40}, "40"},
+		{"-10", Offset{Value: -10}, "-10"},
+		{"12.5", Offset{Value: 12.5}, "12.5"},
+		{"50%", Offset{Value: 50, Percent: true}, "50%"},
+		{"50 %", Offset{Value: 50, Percent: true}, "50%"},
+		{"-25%", Offset{Value: -25, Percent: true}, "-25%"},
+		{"-0", Offset{Value: math.Copysign(0, -1)}, "-0"},
+	} {
+		o, err := ParseOffset(c.in)
+		if err != nil || o != c.expected || math.Signbit(o.Value) != math.Signbit(c.expected.Value) {
+			t.Errorf("%q: expected %+v, got %+v, %v", c.in, c.expected, o, err)
+			continue
+		}
+		if s := o.String(); s != c.str {
+			t.Errorf("%q: expected %q, got %q", c.in, c.str, s)
+		}
+	}
+	for _, in := range []string{"%", "ten", "10px", "5%%"} {
+		if _, err := ParseOffset(in); err == nil {
+			t.Errorf("%q: expected an error", in)
+		}
+	}
+}
+
+func TestOffsetResolve(t *testing.T) {
+	for _, c := range []struct {
+		offset   string
+		length   int
+		expected float64
+	}{
+		{"0", 200, 0},
+		{"40", 200, 40},
+		{"-10", 200, 190},
+		{"-0", 200, 200},
+		{"50%", 200, 100},
+		{"-25%", 200, 150},
+		{"-0%", 200, 200},
+		{"12.5%", 100, 12.5},
+		{"250", 200, 250},
+	} {
+		o, err := ParseOffset(c.offset)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if v := o.resolve(c.length); v != c.expected {
+			t.Errorf("%s of %d: expected %v, got %v", c.offset, c.length, c.expected, v)
+		}
+	}
+}
+
+func TestPlacementResolve(t *testing.T) {
+	// A 100x50 background that does not start at the origin.
+	bg := image.Rect(10, 20, 110, 70)
+	for _, c := range []struct {
+		x, y     string
+		anchor   Anchor
+		size     image.Point
+		expected image.Point
+	}{
+		{"0", "0", AnchorTopLeft, image.Pt(20, 10), image.Pt(10, 20)},
+		{"5", "6", AnchorTopLeft, image.Pt(20, 10), image.Pt(15, 26)},
+		{"50%", "50%", AnchorCenter, image.Pt(20, 10), image.Pt(50, 40)},
+		{"-0", "-0", AnchorBottomRight, image.Pt(20, 10), image.Pt(90, 60)},
+		{"-5", "10", AnchorRight, image.Pt(20, 10), image.Pt(85, 25)},
+		{"50%", "0", AnchorTop, image.Pt(20, 10), image.Pt(50, 20)},
+		{"0", "-0", AnchorBottomLeft, image.Pt(20, 10), image.Pt(10, 60)},
+		// Half pixels are rounded up.
+		{"0", "0", AnchorCenter, image.Pt(3, 3), image.Pt(9, 19)},
+		{"50%", "50%", AnchorCenter, image.Pt(5, 5), image.Pt(58, 43)},
+		// Overlays may be placed partly off the background.
+		{"-0", "0", AnchorTopLeft, image.Pt(20, 10), image.Pt(110, 20)},
+	} {
+		x, _ := ParseOffset(c.x)
+		y, _ := ParseOffset(c.y)
+		p := Placement{X: x, Y: y, Anchor: c.anchor}
+		if pt := p.Resolve(bg, c.size); pt != c.expected {
+			t.Errorf("%s, %s from %s of %v: expected %v, got %v", c.x, c.y, c.anchor, c.size, c.expected, pt)
+		}
+	}
+}
+
+////////////////////////////////////////////////////////////////////////////////
@@ -3,14 +3,77 @@ package qr
 ////////////////////////////////////////////////////////////////////////////////
 
 import (
+	"fmt"
 	"image"
 	"image/color"
+	"strings"
 
+	"github.com/disintegration/imaging"
 	qrcode "github.com/skip2/go-qrcode"
 )
 
 ////////////////////////////////////////////////////////////////////////////////
 
+// libraryQuietZone is the width of the border (in modules) that the qrcode
+// package adds around its bitmaps.
+const libraryQuietZone = 4
+
+// levels maps the names of the error recovery levels to the library's.
+var levels = map[string]qrcode.RecoveryLevel{
+	"":        qrcode.Highest,
+	"low":     qrcode.Low,
+	"medium":  qrcode.Medium,
+	"high":    qrcode.High,
+	"highest": qrcode.Highest,
+}
+
+// Options control how the value is encoded.  The smallest version (number of
+// modules) that fits the value at the recovery level is used, within the
+// optional bounds.
+type Options struct {
+	Level      string // error recovery: low, medium, high, highest (default)
+	QuietZone  int    // width of the border around the code, in modules (default: none)
+	MinVersion int    // smallest version (1-40), or 0
+	MaxVersion int    // largest version (1-40), or 0
+
+	// Scale is how the modules are scaled to the size of the code.
+	Scale  string // smooth (default) or crisp
+	Center bool   // center a crisp code in a box of the full size
+}
+
+// Validate returns an error if any of the options are invalid.
+func (opts Options) Validate() error {
+	if _, ok := levels[strings.ToLower(opts.Level)]; !ok {
+		return fmt.Errorf("%s is not a valid qr level (low, medium, high, highest)", opts.Level)
+	}
+	if opts.QuietZone < 0 {
+		return fmt.Errorf("qr quiet zone must not be negative")
+	}
+	for _, v := range []int{opts.MinVersion, opts.MaxVersion} {
+		if v < 0 || v > 40 {
+			return fmt.Errorf("qr version must be between 1 and 40, got %d", v)
+		}
+	}
+	if opts.MinVersion > 0 && opts.MaxVersion > 0 && opts.MinVersion > opts.MaxVersion {
+		return fmt.Errorf("qr min version %d is greater than the max version %d", opts.MinVersion, opts.MaxVersion)
+	}
+	switch strings.ToLower(opts.Scale) {
+	case "", "smooth", "crisp":
+	default:
+		return fmt.Errorf("%s is not a valid qr scale (smooth, crisp)", opts.Scale)
+	}
+	return nil
+}
+
+func (opts Options) level() string {
+	if len(opts.Level) == 0 {
+		return "highest"
+	}
+	return strings.ToLower(opts.Level)
+}
+
+////////////////////////////////////////////////////////////////////////////////
+
 type Overlay struct {
 	rotation   int
 	xoff, yoff int
@@ -18,9 +81,11 @@ type Overlay struct {
 	value      string
 	fg         color.Color
 	bg         color.Color
+	opts       Options
+	report     string // version and size the code was rendered at
 }
 
-func NewOverlay(ro, x, y, w int, fg, bg color.Color, value string) *Overlay {
+func NewOverlay(ro, x, y, w int, fg, bg color.Color, value string, opts Options) *Overlay {
 	return &Overlay{
 		rotation: ro,
 		xoff:     x,
@@ -29,25 +94,125 @@ func NewOverlay(ro, x, y, w int, fg, bg color.Color, value string) *Overlay {
 		value:    value,
 		fg:       fg,
 		bg:       bg,
+		opts:     opts,
 	}
 }
 
 ////////////////////////////////////////////////////////////////////////////////
 
+// encode returns the version of the code for the value, within the versions
+// allowed, and its modules surrounded by a quiet zone of the configured width.
+// It fails if the code does not fit in `size` pixels.
+func encode(value string, opts Options, size int) (int, [][]bool, error) {
+	if err := opts.Validate(); err != nil {
+		return 0, nil, err
+	}
+	level := levels[opts.level()]
+	qr, err := qrcode.New(value, level)
+	if err != nil {
+		return 0, nil, err
+	}
+	version := qr.VersionNumber
+	if max := opts.MaxVersion; max > 0 && version > max {
+		return 0, nil, fmt.Errorf("content needs qr version %d at the %s level, the max version is %d", version, opts.level(), max)
+	}
+
+	bitmap := qr.Bitmap()
+	bitmap = bitmap[libraryQuietZone : len(bitmap)-libraryQuietZone]
+	for y := range bitmap {
+		bitmap[y] = bitmap[y][libraryQuietZone : len(bitmap[y])-libraryQuietZone]
+	}
+	if min := opts.MinVersion; min > version {
+		version = min
+		if bitmap, err = encodeVersion(value, version, level); err != nil {
+			return 0, nil, fmt.Errorf("%s at the %s level", err.Error(), opts.level())
+		}
+	}
+
+	inner := len(bitmap)
+	n := inner + 2*opts.QuietZone
+	if size < n {
+		return 0, nil, fmt.Errorf("qr version %d needs %d modules (with the quiet zone), which do not fit in %d pixels", version, n, size)
+	}
+
+	modules := make([][]bool, n)
+	for y := range modules {
+		modules[y] = make([]bool, n)
+		for x := range modules[y] {
+			by, bx := y-opts.QuietZone, x-opts.QuietZone
+			if by >= 0 && by < inner && bx >= 0 && bx < inner {
+				modules[y][x] = bitmap[by][bx]
+			}
+		}
+	}
+	return version, modules, nil
+}
+
+// Check returns an error if the value cannot be encoded with the options, or
+// if the code does not fit in `size` pixels.
+func Check(value string, opts Options, size int) error {
+	_, _, err := encode(value, opts, size)
+	return err
+}
+
 func (o *Overlay) Render() (image.Image, int, int, int, error) {
-	qr, err := qrcode.New(o.value, qrcode.Highest)
+	version, modules, err := encode(o.value, o.opts, o.width)
 	if err != nil {
 		return nil, 0, 0, 0, err
 	}
+	n := len(modules)
 
-	qr.ForegroundColor = o.fg
-	qr.BackgroundColor = o.bg
+	// The modules are drawn at the largest whole number of pixels that fits
+	// the width.  A crisp code is left at that size (or centered in the
+	// width), with hard module edges.  Otherwise the code is scaled up to eat
+	// the remainder, using the Lanczos filter.
+	ppm := o.width / n
+	crisp := strings.ToLower(o.opts.Scale) == "crisp"
+	size, offset := n*ppm, 0
+	if crisp && o.opts.Center {
+		size, offset = o.width, (o.width-n*ppm)/2
+	}
+	img := image.NewPaletted(image.Rect(0, 0, size, size), color.Palette{o.bg, o.fg})
+	for y, row := range modules {
+		for x, v := range row {
+			if v {
+				for j := offset + y*ppm; j < offset+(y+1)*ppm; j++ {
+					for i := offset + x*ppm; i < offset+(x+1)*ppm; i++ {
+						img.SetColorIndex(i, j, 1)
+					}
+				}
+			}
+		}
+	}
+
+	o.report = fmt.Sprintf("qr version %d (%s level), %d modules", version, o.opts.level(), n)
+	if crisp {
+		o.report += fmt.Sprintf(" of %dpx, rendered at %dx%d", ppm, n*ppm, n*ppm)
+		if size != n*ppm {
+			o.report += fmt.Sprintf(" in %dx%d", size, size)
+		}
+		return img, o.rotation, o.xoff, o.yoff, nil
+	}
+	return imaging.Resize(img, o.width, o.width, imaging.Lanczos), o.rotation, o.xoff, o.yoff, nil
+}
+
+// Size returns the size that the code for the value is rendered at, which is
+// less than the requested size for crisp codes that are not centered.
+func Size(value string, opts Options, size int) (int, error) {
+	_, modules, err := encode(value, opts, size)
+	if err != nil {
+		return 0, err
+	}
+	if strings.ToLower(opts.Scale) == "crisp" && !opts.Center {
+		n := len(modules)
+		return n * (size / n), nil
+	}
+	return size, nil
+}
 
-	// Note: We have a custom version of the `qr` library with one small
-	// additional feature to scale the image up to avoid the QR having a large
-	// offset. The scaling algorithm uses Lanczos3 as the filter, and can
-	// be disabled if the results are undesirable.
-	return qr.ImageNoPadding(o.width), o.rotation, o.xoff, o.yoff, nil
+// Report describes the version of the code that was rendered.
+func (o *Overlay) Report() string {
+	return o.report
 }
 
 ////////////////////////////////////////////////////////////////////////////////
@@ -0,0 +1,485 @@
+package qr
+
+////////////////////////////////////////////////////////////////////////////////
+
+import (
+	"fmt"
+	"strings"
+
+	qrcode "github.com/skip2/go-qrcode"
+	"github.com/skip2/go-qrcode/bitset"
+	"github.com/skip2/go-qrcode/reedsolomon"
+)
+
+////////////////////////////////////////////////////////////////////////////////
+
+// The qrcode package always picks the smallest version that fits the data, so
+// codes of a larger (forced) version are built here, following ISO/IEC 18004.
+// The tables are indexed by the recovery level (low, medium, high, highest)
+// and the version.
+
+var ecCodewordsPerBlock = [4][41]int{
+	{0, 7, 10, 15, 20, 26, 18, 20, 24, 30, 18, 20, 24, 26, 30, 22, 24, 28, 30, 28, 28, 28, 28, 30, 30, 26, 28, 30, 30, 30, 30, 30, 30, 30, 30, 30, 30, 30, 30, 30, 30},
+	{0, 10, 16, 26, 18, 24, 16, 18, 22, 22, 26, 30, 22, 22, 24, 24, 28, 28, 26, 26, 26, 26, 28, 28, 28, 28, 28, 28, 28, 28, 28, 28, 28, 28, 28, 28, 28, 28, 28, 28, 28},
+	{0, 13, 22, 18, 26, 18, 24, 18, 22, 20, 24, 28, 26, 24, 20, 30, 24, 28, 28, 26, 30, 28, 30, 30, 30, 30, 28, 30, 30, 30, 30, 30, 30, 30, 30, 30, 30, 30, 30, 30, 30},
+	{0, 17, 28, 22, 16, 22, 28, 26, 26, 24, 28, 24, 28, 22, 24, 24, 30, 28, 28, 26, 28, 30, 24, 30, 30, 30, 30, 30, 30, 30, 30, 30, 30, 30, 30, 30, 30, 30, 30, 30, 30},
+}
+
+var ecBlocks = [4][41]int{
+	{0, 1, 1, 1, 1, 1, 2, 2, 2, 2, 4, 4, 4, 4, 4, 6, 6, 6, 6, 7, 8, 8, 9, 9, 10, 12, 12, 12, 13, 14, 15, 16, 17, 18, 19, 19, 20, 21, 22, 24, 25},
+	{0, 1, 1, 1, 2, 2, 4, 4, 4, 5, 5, 5, 8, 9, 9, 10, 10, 11, 13, 14, 16, 17, 17, 18, 20, 21, 23, 25, 26, 28, 29, 31, 33, 35, 37, 38, 40, 43, 45, 47, 49},
+	{0, 1, 1, 2, 2, 4, 4, 6, 6, 8, 8, 8, 10, 12, 16, 12, 17, 16, 18, 21, 20, 23, 23, 25, 27, 29, 34, 34, 35, 38, 40, 43, 45, 48, 51, 53, 56, 59, 62, 65, 68},
+	{0, 1, 1, 2, 4, 4, 4, 5, 6, 8, 8, 11, 11, 16, 16, 18, 16, 19, 21, 25, 25, 25, 34, 30, 32, 35, 37, 40, 42, 45, 48, 51, 54, 57, 60, 63, 66, 70, 74, 77, 81},
+}
+
+// formatLevels are the bits that identify each recovery level in the format
+// information.
+var formatLevels = [4]uint32{1, 0, 3, 2}
+
+const alphanumeric = "0123456789ABCDEFGHIJKLMNOPQRSTUVWXYZ $%*+-./:"
+
+////////////////////////////////////////////////////////////////////////////////
+
+// rawCodewords returns the number of codewords (data and error correction)
+// that fit in a code of the version.
+func rawCodewords(version int) int {
+	n := (16*version+128)*version + 64
+	if version >= 2 {
+		align := version/7 + 2
+		n -= (25*align-10)*align - 55
+		if version >= 7 {
+			n -= 36
+		}
+	}
+	return n / 8
+}
+
+// dataCodewords returns the number of data codewords of a code of the version
+// at the recovery level.
+func dataCodewords(version int, level qrcode.RecoveryLevel) int {
+	return rawCodewords(version) - ecCodewordsPerBlock[level][version]*ecBlocks[level][version]
+}
+
+// alignmentPositions returns the row (and column) of the center of each
+// alignment pattern of a code of the version.
+func alignmentPositions(version int) []int {
+	if version == 1 {
+		return nil
+	}
+	n := version/7 + 2
+	step := (version*8 + n*3 + 5) / (n*4 - 4) * 2
+	pos := make([]int, n)
+	pos[0] = 6
+	for i, p := n-1, 17+4*version-7; i >= 1; i, p = i-1, p-step {
+		pos[i] = p
+	}
+	return pos
+}
+
+////////////////////////////////////////////////////////////////////////////////
+
+// encodeData returns the data codewords of the value, in the single mode that
+// encodes it most compactly, for a code of the version at the recovery level.
+func encodeData(value string, version int, level qrcode.RecoveryLevel) ([]byte, error) {
+	sizes := 0 // index of the character count sizes for the version
+	if version >= 27 {
+		sizes = 2
+	} else if version >= 10 {
+		sizes = 1
+	}
+
+	numeric := strings.Trim(value, "0123456789") == ""
+	alnum := true
+	for _, r := range value {
+		if !strings.ContainsRune(alphanumeric, r) {
+			alnum = false
+			break
+		}
+	}
+
+	b := bitset.New()
+	switch {
+	case numeric:
+		b.AppendUint32(0x1, 4)
+		b.AppendUint32(uint32(len(value)), [3]int{10, 12, 14}[sizes])
+		for i := 0; i < len(value); i += 3 {
+			group := value[i:min(i+3, len(value))]
+			v := uint32(0)
+			for _, c := range group {
+				v = 10*v + uint32(c-'0')
+			}
+			b.AppendUint32(v, 3*len(group)+1)
+		}
+	case alnum:
+		b.AppendUint32(0x2, 4)
+		b.AppendUint32(uint32(len(value)), [3]int{9, 11, 13}[sizes])
+		for i := 0; i < len(value); i += 2 {
+			v := uint32(strings.IndexByte(alphanumeric, value[i]))
+			if i+1 < len(value) {
+				v = 45*v + uint32(strings.IndexByte(alphanumeric, value[i+1]))
+				b.AppendUint32(v, 11)
+			} else {
+				b.AppendUint32(v, 6)
+			}
+		}
+	default:
+		b.AppendUint32(0x4, 4)
+		b.AppendUint32(uint32(len(value)), [3]int{8, 16, 16}[sizes])
+		b.AppendBytes([]byte(value))
+	}
+
+	capacity := 8 * dataCodewords(version, level)
+	if b.Len() > capacity {
+		return nil, fmt.Errorf("content needs %d bits, which do not fit in the %d bits of qr version %d", b.Len(), capacity, version)
+	}
+
+	// Terminate the data, and pad it to the capacity of the version.
+	b.AppendNumBools(min(4, capacity-b.Len()), false)
+	b.AppendNumBools((8-b.Len()%8)%8, false)
+	for pad := byte(0xec); b.Len() < capacity; pad ^= 0xec ^ 0x11 {
+		b.AppendByte(pad, 8)
+	}
+
+	data := make([]byte, b.Len()/8)
+	for i := range data {
+		data[i] = b.ByteAt(8 * i)
+	}
+	return data, nil
+}
+
+// interleave splits the data codewords into blocks, adds the error correction
+// codewords of each, and interleaves the blocks as they are placed in a code
+// of the version.
+func interleave(data []byte, version int, level qrcode.RecoveryLevel) []byte {
+	nblocks := ecBlocks[level][version]
+	necc := ecCodewordsPerBlock[level][version]
+	raw := rawCodewords(version)
+	nshort := nblocks - raw%nblocks
+	shortLen := raw/nblocks - necc // data codewords in the short blocks
+
+	blocks := make([][]byte, nblocks)
+	eccs := make([][]byte, nblocks)
+	for i, k := 0, 0; i < nblocks; i++ {
+		n := shortLen
+		if i >= nshort {
+			n++
+		}
+		blocks[i] = data[k : k+n]
+		k += n
+
+		b := bitset.New()
+		b.AppendBytes(blocks[i])
+		ecc := reedsolomon.Encode(b, necc)
+		for j := 0; j < necc; j++ {
+			eccs[i] = append(eccs[i], ecc.ByteAt(8*(n+j)))
+		}
+	}
+
+	result := make([]byte, 0, raw)
+	for j := 0; j <= shortLen; j++ {
+		for i := range blocks {
+			if j < len(blocks[i]) {
+				result = append(result, blocks[i][j])
+			}
+		}
+	}
+	for j := 0; j < necc; j++ {
+		for i := range eccs {
+			result = append(result, eccs[i][j])
+		}
+	}
+	return result
+}
+
+////////////////////////////////////////////////////////////////////////////////
+
+// symbol is the grid of modules of a code, indexed by row and column.
+type symbol struct {
+	size     int
+	modules  [][]bool
+	function [][]bool // modules of the function patterns, which hold no data
+}
+
+func newSymbol(version int) *symbol {
+	s := &symbol{size: 17 + 4*version}
+	s.modules = make([][]bool, s.size)
+	s.function = make([][]bool, s.size)
+	for y := range s.modules {
+		s.modules[y] = make([]bool, s.size)
+		s.function[y] = make([]bool, s.size)
+	}
+	return s
+}
+
+func (s *symbol) set(x, y int, dark bool) {
+	s.modules[y][x] = dark
+	s.function[y][x] = true
+}
+
+// drawFunctionPatterns draws the finder, timing and alignment patterns, and
+// the version information.  The format information is drawn with the mask.
+func (s *symbol) drawFunctionPatterns(version int) {
+	for i := 0; i < s.size; i++ {
+		s.set(6, i, i%2 == 0)
+		s.set(i, 6, i%2 == 0)
+	}
+
+	for _, c := range [][2]int{{3, 3}, {s.size - 4, 3}, {3, s.size - 4}} {
+		for dy := -4; dy <= 4; dy++ {
+			for dx := -4; dx <= 4; dx++ {
+				x, y := c[0]+dx, c[1]+dy
+				if x >= 0 && x < s.size && y >= 0 && y < s.size {
+					d := max(abs(dx), abs(dy))
+					s.set(x, y, d != 2 && d != 4)
+				}
+			}
+		}
+	}
+
+	pos := alignmentPositions(version)
+	last := len(pos) - 1
+	for i := range pos {
+		for j := range pos {
+			if (i == 0 && j == 0) || (i == 0 && j == last) || (i == last && j == 0) {
+				continue // overlaps a finder pattern
+			}
+			for dy := -2; dy <= 2; dy++ {
+				for dx := -2; dx <= 2; dx++ {
+					s.set(pos[i]+dx, pos[j]+dy, max(abs(dx), abs(dy)) != 1)
+				}
+			}
+		}
+	}
+
+	// Reserve the format information, along with the dark module.
+	s.drawFormat(0)
+
+	if version >= 7 {
+		rem := uint32(version)
+		for i := 0; i < 12; i++ {
+			rem = (rem << 1) ^ ((rem >> 11) * 0x1f25)
+		}
+		bits := uint32(version)<<12 | rem
+		for i := 0; i < 18; i++ {
+			dark := (bits>>uint(i))&1 != 0
+			a, b := s.size-11+i%3, i/3
+			s.set(a, b, dark)
+			s.set(b, a, dark)
+		}
+	}
+}
+
+// drawFormat draws both copies of the format information.
+func (s *symbol) drawFormat(bits uint32) {
+	bit := func(i int) bool {
+		return (bits>>uint(i))&1 != 0
+	}
+	for i := 0; i <= 5; i++ {
+		s.set(8, i, bit(i))
+	}
+	s.set(8, 7, bit(6))
+	s.set(8, 8, bit(7))
+	s.set(7, 8, bit(8))
+	for i := 9; i < 15; i++ {
+		s.set(14-i, 8, bit(i))
+	}
+	for i := 0; i < 8; i++ {
+		s.set(s.size-1-i, 8, bit(i))
+	}
+	for i := 8; i < 15; i++ {
+		s.set(8, s.size-15+i, bit(i))
+	}
+	s.set(8, s.size-8, true)
+}
+
+// drawCodewords places the codewords in the modules that are not part of a
+// function pattern, in pairs of columns zigzagging up and down from the
+// bottom right.  Any remaining modules are left light.
+func (s *symbol) drawCodewords(data []byte) {
+	i := 0
+	for right := s.size - 1; right >= 1; right -= 2 {
+		if right == 6 {
+			right = 5 // skip the vertical timing pattern
+		}
+		up := (right+1)&2 == 0
+		for vert := 0; vert < s.size; vert++ {
+			y := vert
+			if up {
+				y = s.size - 1 - vert
+			}
+			for j := 0; j < 2; j++ {
+				x := right - j
+				if !s.function[y][x] && i < 8*len(data) {
+					s.modules[y][x] = (data[i/8]>>uint(7-i%8))&1 != 0
+					i++
+				}
+			}
+		}
+	}
+}
+
+// applyMask inverts the data modules that the mask pattern selects.  Since it
+// is its own inverse, applying it again removes the mask.
+func (s *symbol) applyMask(mask int) {
+	for y := 0; y < s.size; y++ {
+		for x := 0; x < s.size; x++ {
+			var invert bool
+			switch mask {
+			case 0:
+				invert = (x+y)%2 == 0
+			case 1:
+				invert = y%2 == 0
+			case 2:
+				invert = x%3 == 0
+			case 3:
+				invert = (x+y)%3 == 0
+			case 4:
+				invert = (x/3+y/2)%2 == 0
+			case 5:
+				invert = x*y%2+x*y%3 == 0
+			case 6:
+				invert = (x*y%2+x*y%3)%2 == 0
+			case 7:
+				invert = ((x+y)%2+x*y%3)%2 == 0
+			}
+			if invert && !s.function[y][x] {
+				s.modules[y][x] = !s.modules[y][x]
+			}
+		}
+	}
+}
+
+// formatBits returns the format information of the recovery level and mask,
+// with its error correction bits.
+func formatBits(level qrcode.RecoveryLevel, mask int) uint32 {
+	data := formatLevels[level]<<3 | uint32(mask)
+	rem := data
+	for i := 0; i < 10; i++ {
+		rem = (rem << 1) ^ ((rem >> 9) * 0x537)
+	}
+	return (data<<10 | rem) ^ 0x5412
+}
+
+// penalty scores how hard the masked symbol is to scan: long runs of a color,
+// 2x2 blocks of a color, patterns that look like a finder and an imbalance of
+// dark and light modules.
+func (s *symbol) penalty() int {
+	score, dark := 0, 0
+	finder := []bool{true, false, true, true, true, false, true}
+	for i := 0; i < s.size; i++ {
+		for _, at := range []func(j int) bool{
+			func(j int) bool { return s.modules[i][j] },
+			func(j int) bool { return s.modules[j][i] },
+		} {
+			run := 1
+			for j := 1; j <= s.size; j++ {
+				if j < s.size && at(j) == at(j-1) {
+					run++
+					continue
+				}
+				if run >= 5 {
+					score += 3 + run - 5
+				}
+				run = 1
+			}
+
+			// A finder pattern with four light modules on either side.
+			for j := 0; j+7 <= s.size; j++ {
+				match := true
+				for k, v := range finder {
+					if at(j+k) != v {
+						match = false
+						break
+					}
+				}
+				if !match {
+					continue
+				}
+				before, after := true, true
+				for k := 1; k <= 4; k++ {
+					before = before && (j-k < 0 || !at(j-k))
+					after = after && (j+6+k >= s.size || !at(j+6+k))
+				}
+				if before || after {
+					score += 40
+				}
+			}
+		}
+	}
+	for y := 0; y < s.size; y++ {
+		for x := 0; x < s.size; x++ {
+			if s.modules[y][x] {
+				dark++
+			}
+			if x > 0 && y > 0 {
+				c := s.modules[y][x]
+				if s.modules[y-1][x] == c && s.modules[y][x-1] == c && s.modules[y-1][x-1] == c {
+					score += 3
+				}
+			}
+		}
+	}
+	total := s.size * s.size
+	score += 10 * ((abs(20*dark-10*total)+total-1)/total - 1)
+	return score
+}
+
+// buildSymbol returns the symbol of the codewords for a code of the version
+// at the recovery level, with the mask applied.
+func buildSymbol(codewords []byte, version int, level qrcode.RecoveryLevel, mask int) *symbol {
+	s := newSymbol(version)
+	s.drawFunctionPatterns(version)
+	s.drawCodewords(codewords)
+	s.applyMask(mask)
+	s.drawFormat(formatBits(level, mask))
+	return s
+}
+
+// encodeVersion returns the modules (without a quiet zone) of the code for the
+// value at exactly the version, with the mask that is easiest to scan.  It
+// fails if the value does not fit in the version at the recovery level.
+func encodeVersion(value string, version int, level qrcode.RecoveryLevel) ([][]bool, error) {
+	if version < 1 || version > 40 {
+		return nil, fmt.Errorf("qr version must be between 1 and 40, got %d", version)
+	}
+	data, err := encodeData(value, version, level)
+	if err != nil {
+		return nil, err
+	}
+	codewords := interleave(data, version, level)
+
+	var best *symbol
+	bestScore := 0
+	for mask := 0; mask < 8; mask++ {
+		s := buildSymbol(codewords, version, level, mask)
+		if score := s.penalty(); best == nil || score < bestScore {
+			best, bestScore = s, score
+		}
+	}
+	return best.modules, nil
+}
+
+////////////////////////////////////////////////////////////////////////////////
+
+func min(a, b int) int {
+	if a < b {
+		return a
+	}
+	return b
+}
+
+func max(a, b int) int {
+	if a > b {
+		return a
+	}
+	return b
+}
+
+func abs(a int) int {
+	if a < 0 {
+		return -a
+	}
+	return a
+}
+
+////////////////////////////////////////////////////////////////////////////////
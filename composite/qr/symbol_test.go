@@ -0,0 +1,144 @@
+package qr
+
+////////////////////////////////////////////////////////////////////////////////
+
+import (
+	"math/rand"
+	"strings"
+	"testing"
+
+	qrcode "github.com/skip2/go-qrcode"
+)
+
+////////////////////////////////////////////////////////////////////////////////
+
+// libraryMask returns the mask of a symbol from its format information.
+func libraryMask(t *testing.T, modules [][]bool, level qrcode.RecoveryLevel) int {
+	bits := uint32(0)
+	for i, p := range [][2]int{{8, 0}, {8, 1}, {8, 2}, {8, 3}, {8, 4}, {8, 5}, {8, 7}, {8, 8}, {7, 8}, {5, 8}, {4, 8}, {3, 8}, {2, 8}, {1, 8}, {0, 8}} {
+		if modules[p[1]][p[0]] {
+			bits |= 1 << uint(i)
+		}
+	}
+	for mask := 0; mask < 8; mask++ {
+		if formatBits(level, mask) == bits {
+			return mask
+		}
+	}
+	t.Fatalf("invalid format information %015b", bits)
+	return 0
+}
+
+// randomValue returns `n` characters drawn from `chars`.
+func randomValue(r *rand.Rand, chars string, n int) string {
+	b := make([]byte, n)
+	for i := range b {
+		b[i] = chars[r.Intn(len(chars))]
+	}
+	return string(b)
+}
+
+////////////////////////////////////////////////////////////////////////////////
+
+// The symbols built here match those of the qrcode package, for the largest
+// value of each version and level, which the package must then pick too.  The
+// values are in a single mode, as it splits mixed values into segments.
+func TestBuildSymbolMatchesLibrary(t *testing.T) {
+	r := rand.New(rand.NewSource(1))
+	for level := qrcode.Low; level <= qrcode.Highest; level++ {
+		for version := 1; version <= 40; version++ {
+			sizes := 0
+			if version >= 27 {
+				sizes = 2
+			} else if version >= 10 {
+				sizes = 1
+			}
+			bits := 8*dataCodewords(version, level) - 4
+
+			values := []string{}
+			n := (bits - [3]int{8, 16, 16}[sizes]) / 8
+			values = append(values, randomValue(r, "abcdefghijklmnopqrstuvwxyz", n))
+			if version%7 == 1 {
+				b := bits - [3]int{10, 12, 14}[sizes]
+				n = 3*(b/10) + [10]int{0, 0, 0, 0, 1, 1, 1, 2, 2, 2}[b%10]
+				values = append(values, randomValue(r, "0123456789", n))
+				b = bits - [3]int{9, 11, 13}[sizes]
+				n = 2*(b/11) + b%11/6
+				values = append(values, randomValue(r, "ABCDEFGHIJKLMNOPQRSTUVWXYZ $%*+-./:", n))
+			}
+
+			for _, value := range values {
+				lib, err := qrcode.New(value, level)
+				if err != nil {
+					t.Fatal(err)
+				}
+				if lib.VersionNumber != version {
+					t.Fatalf("%q at level %d is version %d, expected %d", value[:3], level, lib.VersionNumber, version)
+				}
+				bitmap := lib.Bitmap()
+				inner := [][]bool{}
+				for _, row := range bitmap[libraryQuietZone : len(bitmap)-libraryQuietZone] {
+					inner = append(inner, row[libraryQuietZone:len(row)-libraryQuietZone])
+				}
+				mask := libraryMask(t, inner, level)
+
+				data, err := encodeData(value, version, level)
+				if err != nil {
+					t.Fatalf("version %d at level %d: %s", version, level, err.Error())
+				}
+				s := buildSymbol(interleave(data, version, level), version, level, mask)
+				for y := range inner {
+					for x := range inner[y] {
+						if s.modules[y][x] != inner[y][x] {
+							t.Fatalf("%q at version %d and level %d differs at (%d, %d)", value[:3], version, level, x, y)
+						}
+					}
+				}
+			}
+		}
+	}
+}
+
+func TestEncodeMinVersion(t *testing.T) {
+	for _, tc := range []struct {
+		value string
+		opts  Options
+		n     int
+		err   string
+	}{
+		{"hello", Options{}, 21, ""},
+		{"hello", Options{MinVersion: 5}, 37, ""},
+		{"hello", Options{MinVersion: 40, Level: "low"}, 177, ""},
+		{"hello", Options{MinVersion: 2, QuietZone: 4}, 25 + 8, ""},
+		{strings.Repeat("x", 200), Options{MaxVersion: 5}, 0, "the max version is 5"},
+		{"hello", Options{MinVersion: 40}, 0, "do not fit in 100 pixels"},
+	} {
+		_, modules, err := encode(tc.value, tc.opts, 100)
+		if len(tc.err) > 0 {
+			if err == nil || !strings.Contains(err.Error(), tc.err) {
+				t.Errorf("%+v: error is %v, expected %q", tc.opts, err, tc.err)
+			}
+			continue
+		}
+		if tc.n > 100 {
+			_, modules, err = encode(tc.value, tc.opts, tc.n)
+		}
+		if err != nil {
+			t.Errorf("%+v: %s", tc.opts, err.Error())
+		} else if len(modules) != tc.n {
+			t.Errorf("%+v: code is %d modules, expected %d", tc.opts, len(modules), tc.n)
+		}
+	}
+}
+
+func TestEncodeVersionTooLong(t *testing.T) {
+	value := strings.Repeat("x", 100)
+	if _, err := encodeVersion(value, 4, qrcode.Highest); err == nil || !strings.Contains(err.Error(), "do not fit") {
+		t.Errorf("expected an error for content that does not fit, got %v", err)
+	}
+	if _, err := encodeVersion(value, 10, qrcode.Highest); err != nil {
+		t.Errorf("unexpected error: %s", err.Error())
+	}
+}
+
+////////////////////////////////////////////////////////////////////////////////
@@ -0,0 +1,96 @@
+package text
+
+////////////////////////////////////////////////////////////////////////////////
+
+import (
+	"fmt"
+	"math"
+
+	"github.com/golang/freetype/truetype"
+	"golang.org/x/image/math/fixed"
+)
+
+////////////////////////////////////////////////////////////////////////////////
+
+const (
+	defaultMinSize = float64(6)
+	fitStep        = float64(0.5) // granularity of the fitted font size in pt
+)
+
+////////////////////////////////////////////////////////////////////////////////
+
+// fits returns true if the text laid out at `size` fits within the box.  When
+// wrapping, every line must break between words - a word that had to be split
+// across lines does not fit.
+func (o *Overlay) fits(f *truetype.Font, size float64) bool {
+	face, b := o.layoutAt(f, size)
+	defer face.Close()
+
+	if o.layout.MaxLines > 0 && len(b.lines) > o.layout.MaxLines {
+		return false
+	}
+	if o.layout.Width > 0 && (b.wrapped || b.width > fixed.I(o.layout.Width)) {
+		return false
+	}
+	if o.layout.Height > 0 && b.height > fixed.I(o.layout.Height) {
+		return false
+	}
+	return true
+}
+
+// fitSize returns the largest size (in steps of `fitStep`) that fits within
+// the box for the fit mode.  "shrink" only considers sizes up to the
+// configured size, "grow" only those from it, and "both" the full range
+// between the min and max sizes.  If no size fits, the smallest size is
+// returned along with the overflow flag.
+func (o *Overlay) fitSize(f *truetype.Font) (float64, bool, error) {
+	if o.layout.Width <= 0 && o.layout.Height <= 0 {
+		return 0, false, fmt.Errorf("text fit requires a box width and / or height")
+	}
+
+	minSize := o.layout.MinSize
+	if minSize <= 0 {
+		minSize = defaultMinSize
+	}
+	maxSize := o.layout.MaxSize
+	if maxSize <= 0 {
+		// Nothing can be taller than the box, or wider than the box when it
+		// is a single character.
+		box := math.Max(float64(o.layout.Width), float64(o.layout.Height))
+		maxSize = math.Max(box*72.0/o.dpi, o.size)
+	}
+
+	var lo, hi float64
+	switch o.layout.Fit {
+	case "shrink":
+		lo, hi = math.Min(minSize, o.size), o.size
+	case "grow":
+		lo, hi = o.size, math.Max(maxSize, o.size)
+	case "both":
+		lo, hi = minSize, maxSize
+	default:
+		return 0, false, fmt.Errorf("%s is not a valid text fit mode", o.layout.Fit)
+	}
+	if hi < lo {
+		return 0, false, fmt.Errorf("text fit min size (%v) is larger than the max size (%v)", lo, hi)
+	}
+
+	// Binary search the steps between lo and hi for the largest that fits.
+	steps := int((hi - lo) / fitStep)
+	if !o.fits(f, lo) {
+		return lo, true, nil
+	}
+	best := 0
+	i, j := 1, steps
+	for i <= j {
+		mid := (i + j) / 2
+		if o.fits(f, lo+float64(mid)*fitStep) {
+			best, i = mid, mid+1
+		} else {
+			j = mid - 1
+		}
+	}
+	return lo + float64(best)*fitStep, false, nil
+}
+
+////////////////////////////////////////////////////////////////////////////////
@@ -0,0 +1,81 @@
+package text
+
+////////////////////////////////////////////////////////////////////////////////
+
+import (
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"sync"
+
+	"github.com/golang/freetype"
+	"github.com/golang/freetype/truetype"
+)
+
+////////////////////////////////////////////////////////////////////////////////
+
+// Registry maps font names to font files, and caches each parsed font so that
+// a file is only read and parsed once no matter how many overlays use it.  It
+// is safe for concurrent use.
+type Registry struct {
+	mu    sync.Mutex
+	names map[string]string         // font name -> path
+	fonts map[string]*truetype.Font // cleaned path -> parsed font
+}
+
+func NewRegistry() *Registry {
+	return &Registry{
+		names: map[string]string{},
+		fonts: map[string]*truetype.Font{},
+	}
+}
+
+// Register associates a name with the font file at `path`.  The file is not
+// read until the font is first used.
+func (r *Registry) Register(name, path string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.names[name] = path
+}
+
+// Path returns the path registered for the font name.
+func (r *Registry) Path(name string) (string, bool) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	p, ok := r.names[name]
+	return p, ok
+}
+
+// Font returns the parsed font registered as `name`.
+func (r *Registry) Font(name string) (*truetype.Font, error) {
+	path, ok := r.Path(name)
+	if !ok {
+		return nil, fmt.Errorf("font %q is not defined", name)
+	}
+	return r.Load(path)
+}
+
+// Load returns the parsed font at `path`, reading and parsing it on first use.
+func (r *Registry) Load(path string) (*truetype.Font, error) {
+	key := filepath.Clean(path)
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if f, ok := r.fonts[key]; ok {
+		return f, nil
+	}
+
+	fontBytes, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("unable to read font file %s: %s", path, err.Error())
+	}
+	f, err := freetype.ParseFont(fontBytes)
+	if err != nil {
+		return nil, fmt.Errorf("unable to parse font file %s: %s", path, err.Error())
+	}
+
+	r.fonts[key] = f
+	return f, nil
+}
+
+////////////////////////////////////////////////////////////////////////////////
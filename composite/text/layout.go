@@ -0,0 +1,131 @@
+package text
+
+////////////////////////////////////////////////////////////////////////////////
+
+import (
+	"strings"
+	"unicode/utf8"
+
+	"golang.org/x/image/font"
+	"golang.org/x/image/math/fixed"
+)
+
+////////////////////////////////////////////////////////////////////////////////
+
+// Layout specifies the (optional) box that the text is laid out in, and how
+// the lines are aligned within it.  A zero width disables word wrapping, and
+// a zero width or height sizes the box to fit the text in that dimension.
+type Layout struct {
+	Width      int     // box width in pixels
+	Height     int     // box height in pixels
+	LineHeight float64 // multiple of the font size between baselines
+	Align      string  // left, center, right, justify
+	VAlign     string  // top, middle, bottom
+
+	// Fit picks the largest font size that fits the box.
+	Fit      string  // shrink, grow, both (disabled if empty)
+	MinSize  float64 // smallest size to shrink to
+	MaxSize  float64 // largest size to grow to
+	MaxLines int     // maximum number of lines when fitting (0 is unlimited)
+}
+
+////////////////////////////////////////////////////////////////////////////////
+
+// line is a single laid out line of text.  The `last` flag is set for the
+// final line of each paragraph, which is never justified.
+type line struct {
+	words []string
+	width fixed.Int26_6
+	last  bool
+}
+
+// block is the result of laying out a string with a given face.
+type block struct {
+	lines   []*line
+	width   fixed.Int26_6 // width of the widest line
+	height  fixed.Int26_6 // from the top of the first line to the bottom of the last
+	ascent  fixed.Int26_6
+	pitch   fixed.Int26_6 // distance between baselines
+	space   fixed.Int26_6 // advance of a single space
+	wrapped bool          // set if any word had to be broken to fit the width
+}
+
+// splitParagraphs splits the value on newlines, treating a literal "\n"
+// sequence (as written in single-quoted or plain yaml scalars) as a newline.
+func splitParagraphs(value string) []string {
+	value = strings.Replace(value, `\n`, "\n", -1)
+	value = strings.Replace(value, "\r\n", "\n", -1)
+	return strings.Split(value, "\n")
+}
+
+// layoutText breaks the value into lines that fit within `width` pixels when
+// rendered with `face`.  If `width` is zero, only explicit newlines break lines.
+func layoutText(face font.Face, value string, width int, pitch fixed.Int26_6) *block {
+	m := face.Metrics()
+	b := &block{
+		ascent: m.Ascent,
+		pitch:  pitch,
+		space:  font.MeasureString(face, " "),
+	}
+	maxw := fixed.I(width)
+
+	for _, para := range splitParagraphs(value) {
+		// Without a box width there is nothing to wrap or justify against, so
+		// keep the paragraph (and its spacing) exactly as specified.
+		if width <= 0 {
+			b.lines = append(b.lines, &line{words: []string{para}, width: font.MeasureString(face, para), last: true})
+			continue
+		}
+
+		cur := &line{}
+		for _, word := range strings.Fields(para) {
+			ww := font.MeasureString(face, word)
+
+			// Break words that can never fit on a single line.
+			for ww > maxw && utf8.RuneCountInString(word) > 1 {
+				if len(cur.words) > 0 {
+					b.lines = append(b.lines, cur)
+					cur = &line{}
+				}
+				head, tail := breakWord(face, word, maxw)
+				b.lines = append(b.lines, &line{words: []string{head}, width: font.MeasureString(face, head)})
+				b.wrapped = true
+				word, ww = tail, font.MeasureString(face, tail)
+			}
+
+			nw := ww
+			if len(cur.words) > 0 {
+				nw = cur.width + b.space + ww
+			}
+			if nw > maxw && len(cur.words) > 0 {
+				b.lines = append(b.lines, cur)
+				cur, nw = &line{}, ww
+			}
+			cur.words = append(cur.words, word)
+			cur.width = nw
+		}
+		cur.last = true
+		b.lines = append(b.lines, cur)
+	}
+
+	for _, l := range b.lines {
+		if l.width > b.width {
+			b.width = l.width
+		}
+	}
+	b.height = m.Ascent + m.Descent + pitch*fixed.Int26_6(len(b.lines)-1)
+	return b
+}
+
+// breakWord splits a word at the last rune that fits within `maxw`, always
+// keeping at least one rune in the head.
+func breakWord(face font.Face, word string, maxw fixed.Int26_6) (string, string) {
+	runes := []rune(word)
+	n := 1
+	for n < len(runes) && font.MeasureString(face, string(runes[:n+1])) <= maxw {
+		n++
+	}
+	return string(runes[:n]), string(runes[n:])
+}
+
+////////////////////////////////////////////////////////////////////////////////
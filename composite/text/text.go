@@ -3,41 +3,22 @@ package text
 ////////////////////////////////////////////////////////////////////////////////
 
 import (
+	"fmt"
 	"image"
 	"image/color"
 	"image/draw"
-	"io/ioutil"
 
-	"github.com/golang/freetype"
 	"github.com/golang/freetype/truetype"
 	"golang.org/x/image/font"
+	"golang.org/x/image/math/fixed"
 )
 
 ////////////////////////////////////////////////////////////////////////////////
 
 const (
-	spacing = float64(1.1)
+	defaultLineHeight = float64(1.2)
 )
 
-////////////////////////////////////////////////////////////////////////////////
-
-var (
-	f *truetype.Font
-)
-
-func SetupFont(fontpath string) {
-	// Read the font data.
-	fontBytes, err := ioutil.ReadFile(fontpath)
-	if err != nil {
-		panic("Unable to read font file " + fontpath)
-	}
-
-	f, err = freetype.ParseFont(fontBytes)
-	if err != nil {
-		panic("Unable to parse font file " + fontpath)
-	}
-}
-
 ////////////////////////////////////////////////////////////////////////////////
 ////////////////////////////////////////////////////////////////////////////////
 ////////////////////////////////////////////////////////////////////////////////
@@ -48,66 +29,134 @@ type Overlay struct {
 	size       float64
 	value      string
 	dpi        float64
-	fontPath   string
+	font       *truetype.Font
 	fg         color.Color
 	bg         color.Color
+	layout     Layout
+
+	// Set by Render when the text is fit to the box.
+	renderedSize float64
+	overflow     bool
 }
 
-func NewOverlay(ro, x, y, size, dpi int, fp string, fg, bg color.Color, value string) *Overlay {
+func NewOverlay(ro, x, y, size, dpi int, f *truetype.Font, fg, bg color.Color, value string, layout Layout) *Overlay {
+	if layout.LineHeight <= 0 {
+		layout.LineHeight = defaultLineHeight
+	}
 	return &Overlay{
 		rotation: ro,
 		xoff:     x,
 		yoff:     y,
 		size:     float64(size),
 		dpi:      float64(dpi),
-		fontPath: fp,
+		font:     f,
 		value:    value,
 		fg:       fg,
 		bg:       bg,
+		layout:   layout,
 	}
 }
 
 ////////////////////////////////////////////////////////////////////////////////
 
+// layoutAt lays out the text with the font at the specified size.  The caller
+// is responsible for closing the returned face.
+func (o *Overlay) layoutAt(f *truetype.Font, size float64) (font.Face, *block) {
+	face := truetype.NewFace(f, &truetype.Options{
+		Size:    size,
+		DPI:     o.dpi,
+		Hinting: font.HintingNone,
+	})
+	pitch := fixed.Int26_6(size * o.dpi / 72.0 * o.layout.LineHeight * 64)
+	return face, layoutText(face, o.value, o.layout.Width, pitch)
+}
+
+// Report describes the font size chosen when fitting the text to its box.
+func (o *Overlay) Report() string {
+	if len(o.layout.Fit) == 0 {
+		return ""
+	}
+	if o.overflow {
+		return fmt.Sprintf("text %q does not fit at the minimum size, rendered at %.1fpt", o.value, o.renderedSize)
+	}
+	return fmt.Sprintf("text %q fit at %.1fpt", o.value, o.renderedSize)
+}
+
 func (o *Overlay) Render() (image.Image, int, int, int, error) {
-	SetupFont(o.fontPath)
+	f := o.font
+	if f == nil {
+		return nil, 0, 0, 0, fmt.Errorf("no font specified for text overlay")
+	}
 
-	scale := float64(o.dpi) / 72.0
+	size := o.size
+	if len(o.layout.Fit) > 0 {
+		var err error
+		if size, o.overflow, err = o.fitSize(f); err != nil {
+			return nil, 0, 0, 0, err
+		}
+	}
+	o.renderedSize = size
 
-	// Create an image to render the text on.
-	img := image.NewRGBA(image.Rect(0, 0, int(o.size*spacing*float64(len(o.value))*scale), int(o.size*1.5*scale)))
+	face, b := o.layoutAt(f, size)
+	defer face.Close()
 
-	fg := image.NewUniform(o.fg)
-	bg := image.NewUniform(o.bg)
+	// The box defaults to the size of the laid out text in each dimension
+	// that is not specified.
+	w, h := o.layout.Width, o.layout.Height
+	if w <= 0 {
+		w = b.width.Ceil() + 2
+	}
+	if h <= 0 {
+		h = b.height.Ceil() + 2
+	}
 
-	// Draw onto the image and setup the context.
-	draw.Draw(img, img.Bounds(), bg, image.ZP, draw.Src)
+	img := image.NewRGBA(image.Rect(0, 0, w, h))
+	draw.Draw(img, img.Bounds(), image.NewUniform(o.bg), image.ZP, draw.Src)
 
-	c := freetype.NewContext()
-	c.SetDPI(o.dpi)
-	c.SetFont(f)
-	c.SetFontSize(o.size)
-	c.SetClip(img.Bounds())
-	c.SetDst(img)
-	c.SetSrc(fg)
-	c.SetHinting(font.HintingNone)
+	// Position the block vertically within the box.
+	top := fixed.I(0)
+	switch o.layout.VAlign {
+	case "middle":
+		top = (fixed.I(h) - b.height) / 2
+	case "bottom":
+		top = fixed.I(h) - b.height
+	}
 
-	// Render the text on the context.
-	ptl := freetype.Pt(0, int(c.PointToFixed(o.size)>>6))
-	ptr, err := c.DrawString(o.value, ptl)
-	if err != nil {
-		return nil, 0, 0, 0, err
+	d := &font.Drawer{
+		Dst:  img,
+		Src:  image.NewUniform(o.fg),
+		Face: face,
 	}
+	for i, l := range b.lines {
+		d.Dot.Y = top + b.ascent + b.pitch*fixed.Int26_6(i)
+
+		// Position each line horizontally, and spread the words of justified
+		// lines across the width of the box.
+		gap := b.space
+		extra := fixed.I(w) - l.width
+		switch o.layout.Align {
+		case "center":
+			d.Dot.X = extra / 2
+		case "right":
+			d.Dot.X = extra
+		case "justify":
+			d.Dot.X = 0
+			if !l.last && len(l.words) > 1 {
+				gap += extra / fixed.Int26_6(len(l.words)-1)
+			}
+		default:
+			d.Dot.X = 0
+		}
 
-	xmax := ptr.X.Ceil() + 2
-	ymax := ptr.Y.Ceil() + 2
-	imgout := image.NewRGBA(image.Rect(0, 0, xmax, ymax))
-	for i := 0; i < xmax; i++ {
-		for j := 0; j < ymax; j++ {
-			imgout.Set(i, j, img.At(i, j))
+		for j, word := range l.words {
+			if j > 0 {
+				d.Dot.X += gap
+			}
+			d.DrawString(word)
 		}
 	}
-	return imgout, o.rotation, o.xoff, o.yoff, nil
+
+	return img, o.rotation, o.xoff, o.yoff, nil
 }
 
 ////////////////////////////////////////////////////////////////////////////////
@@ -0,0 +1,493 @@
+package job
+
+////////////////////////////////////////////////////////////////////////////////
+/*
+
+Package job loads imagenie configs and renders their items.  A config is loaded
+(and checked) with `Load`, after which single items can be rendered with
+`Render` or streamed to a writer with `Encode`, and the whole batch can be
+written to the output directory with `Run`.
+
+*/
+////////////////////////////////////////////////////////////////////////////////
+
+import (
+	"bytes"
+	"fmt"
+	"image/color"
+	"io"
+	"log"
+	"strings"
+	"text/template"
+	"time"
+
+	"github.com/golang/freetype/truetype"
+
+	"github.com/sabhiram/imagenie/composite"
+	"github.com/sabhiram/imagenie/composite/cmyk"
+	"github.com/sabhiram/imagenie/composite/filter"
+	"github.com/sabhiram/imagenie/composite/image"
+	"github.com/sabhiram/imagenie/composite/impose"
+	"github.com/sabhiram/imagenie/composite/qr"
+	"github.com/sabhiram/imagenie/composite/text"
+)
+
+////////////////////////////////////////////////////////////////////////////////
+
+// Function map definition, for any text template assistance.
+var (
+	funcMap = template.FuncMap{
+		"mul": func(a, b float64) float64 {
+			return a * b
+		},
+		"add": func(a, b float64) float64 {
+			return a + b
+		},
+		"sub": func(a, b float64) float64 {
+			return a - b
+		},
+		"div": func(a, b float64) float64 {
+			return a / b
+		},
+		"firstHalf": func(s string) string {
+			return s[:len(s)/2]
+		},
+		"secondHalf": func(s string) string {
+			return s[len(s)/2:]
+		},
+		"precise8": func(a float64) string {
+			return fmt.Sprintf("%.8f", a)
+		},
+		"precise4": func(a float64) string {
+			return fmt.Sprintf("%.4f", a)
+		},
+		"precise2": func(a float64) string {
+			return fmt.Sprintf("%.2f", a)
+		},
+	}
+)
+
+////////////////////////////////////////////////////////////////////////////////
+
+// Hex parses a "html" hex color-string, either in the 3 "#f0c" or 6 "#ff1034" digits form.
+// NOTE: This code has been borrowed and adapted from:
+//       https://github.com/lucasb-eyer/go-colorful/blob/master/colors.go
+func Hex(scol string) (color.Color, error) {
+	format := "#%02x%02x%02x"
+	factor := 1.0
+	if len(scol) == 4 {
+		format = "#%1x%1x%1x"
+		factor = 16.0
+	}
+
+	var r, g, b uint8
+	n, err := fmt.Sscanf(scol, format, &r, &g, &b)
+	if err != nil {
+		return color.RGBA{}, err
+	}
+	if n != 3 {
+		return color.RGBA{}, fmt.Errorf("color: %v is not a hex-color", scol)
+	}
+	return color.RGBA{
+		uint8(float64(r) * factor),
+		uint8(float64(g) * factor),
+		uint8(float64(b) * factor),
+		255,
+	}, nil
+}
+
+// ParseColor parses a named ("black", "white", "transparent") or hex color.  An
+// empty string maps to the default color.
+func ParseColor(c string, defaultColor color.Color) (color.Color, error) {
+	switch c {
+	case "black":
+		return color.Black, nil
+	case "transparent":
+		return color.Transparent, nil
+	case "white":
+		return color.White, nil
+	case "":
+		return defaultColor, nil
+	}
+	if c[0] == '#' && (len(c) == 4 || len(c) == 7) {
+		if col, err := Hex(c); err == nil {
+			return col, nil
+		}
+	}
+	return nil, fmt.Errorf("%q is not a valid color", c)
+}
+
+////////////////////////////////////////////////////////////////////////////////
+
+func defaultIntValue(v, def int) int {
+	if v == 0 {
+		return def
+	}
+	return v
+}
+
+func defaultStringValue(v, def string) string {
+	if len(v) == 0 {
+		return def
+	}
+	return v
+}
+
+////////////////////////////////////////////////////////////////////////////////
+
+// OverlayOpts specifies the possible options to configure a given overlay type.
+// The types of overlays that each option applies to are specified in the
+// comment to the right of the declaration.
+type OverlayOpts struct {
+	Type     string           `yaml:"type"`       // Image, QR, Text
+	Rotation int              `yaml:"rotation"`   // Image, QR, Text
+	XOffset  composite.Offset `yaml:"xoffset"`    // Image, QR, Text
+	YOffset  composite.Offset `yaml:"yoffset"`    // Image, QR, Text
+	Anchor   string           `yaml:"anchor"`     // Image, QR, Text
+	Size     int              `yaml:"size"`       // Image, QR, Text
+	Dpi      int              `yaml:"dpi"`        // Text
+	FontPath string           `yaml:"fontpath"`   // Text
+	Font     string           `yaml:"font"`       // Text
+	Template string           `yaml:"template"`   // Image, QR, Text
+	FgColor  string           `yaml:"foreground"` // QR, Text
+	BgColor  string           `yaml:"background"` // QR, Text
+	Blend    string           `yaml:"blend"`      // Image, QR, Text
+	Opacity  *float64         `yaml:"opacity"`    // Image, QR, Text
+
+	Width      int     `yaml:"width"`       // Image, Text
+	Height     int     `yaml:"height"`      // Image, Text
+	LineHeight float64 `yaml:"line_height"` // Text
+	Align      string  `yaml:"align"`       // Text
+	VAlign     string  `yaml:"valign"`      // Text
+	Fit        string  `yaml:"fit"`         // Image, Text
+	MinSize    int     `yaml:"min_size"`    // Text
+	MaxSize    int     `yaml:"max_size"`    // Text
+	MaxLines   int     `yaml:"max_lines"`   // Text
+
+	QRLevel      string `yaml:"qr_level"`       // QR
+	QRQuietZone  int    `yaml:"qr_quiet_zone"`  // QR
+	QRMinVersion int    `yaml:"qr_min_version"` // QR
+	QRMaxVersion int    `yaml:"qr_max_version"` // QR
+	QRScale      string `yaml:"qr_scale"`       // QR
+	QRCenter     bool   `yaml:"qr_center"`      // QR
+
+	Gravity string          `yaml:"gravity"` // Image
+	Filter  string          `yaml:"filter"`  // Image
+	Filters []filter.Filter `yaml:"filters"` // Image
+	Mask    *MaskOpts       `yaml:"mask"`    // Image
+}
+
+// GetRenderable returns a `Renderable` interface based on the underlying overlay
+// options.
+func (o *OverlayOpts) GetRenderable(ctxt map[string]interface{}, cfg *Config) (composite.Renderable, error) {
+	tv, err := o.value(ctxt)
+	if err != nil {
+		return nil, err
+	}
+
+	// Default values in case they are not configured
+	xo := o.XOffset.Int()                // Default: 0
+	yo := o.YOffset.Int()                // Default: 0
+	sz := defaultIntValue(o.Size, 12)    // Default: 12 "pt"
+	dp := defaultIntValue(o.Dpi, 72)     // Default: 72 dpi
+	ro := defaultIntValue(o.Rotation, 0) // Default: 0 degrees
+	op := 1.0                            // Default: fully opaque
+	if o.Opacity != nil {
+		op = *o.Opacity
+	}
+	fg, err := ParseColor(o.FgColor, color.Black)
+	if err != nil {
+		return nil, err
+	}
+	bg, err := ParseColor(o.BgColor, color.Transparent)
+	if err != nil {
+		return nil, err
+	}
+
+	var r composite.Renderable
+	switch o.Type {
+	case "qr":
+		opts, err := o.qrOptions()
+		if err != nil {
+			return nil, err
+		}
+		r = qr.NewOverlay(ro, xo, yo, sz, fg, bg, tv, opts)
+	case "text":
+		f, err := o.textFont(cfg)
+		if err != nil {
+			return nil, err
+		}
+		layout, err := o.textLayout()
+		if err != nil {
+			return nil, err
+		}
+		r = text.NewOverlay(ro, xo, yo, sz, dp, f, fg, bg, tv, layout)
+	case "image":
+		layout, err := o.imageLayout()
+		if err != nil {
+			return nil, err
+		}
+		mask, err := o.Mask.mask()
+		if err != nil {
+			return nil, err
+		}
+		r = image.NewOverlay(ro, xo, yo, tv, layout, o.Filters, mask)
+	default:
+		return nil, fmt.Errorf("invalid renderable for overlay type: %s", o.Type)
+	}
+
+	anchor, err := composite.ParseAnchor(o.Anchor)
+	if err != nil {
+		return nil, err
+	}
+	place := composite.Placement{X: o.XOffset, Y: o.YOffset, Anchor: anchor}
+
+	mode, err := composite.ParseBlendMode(o.Blend)
+	if err != nil {
+		return nil, err
+	}
+	if op < 0 || op > 1 {
+		return nil, fmt.Errorf("opacity must be between 0 and 1, got %v", op)
+	}
+	return composite.NewLayer(r, place, mode, op), nil
+}
+
+// value executes the overlay's template against the context.  The templated
+// value is the string to either print or QR in the case of those overlay
+// types.  In the case of the image type, it is a path to the image to inject
+// to allow for a dynamic range of images to be used.
+func (o *OverlayOpts) value(ctxt map[string]interface{}) (string, error) {
+	var buf bytes.Buffer
+	t, err := parseTemplate(o.Template)
+	if err != nil {
+		return "", err
+	}
+	if err := t.Execute(&buf, ctxt); err != nil {
+		return "", fmt.Errorf("unable to execute template: %s", err.Error())
+	}
+	return buf.String(), nil
+}
+
+// fontFile returns the path of the font file for a text overlay.  A named
+// `font` takes precedence over the overlay's `fontpath`, which in turn
+// overrides the global `fontpath`.  The font named "default" is used if none
+// of these are set.
+func (o *OverlayOpts) fontFile(cfg *Config) (string, error) {
+	if len(o.Font) > 0 {
+		if fp, ok := cfg.fonts.Path(o.Font); ok {
+			return fp, nil
+		}
+		return "", fmt.Errorf("font %q is not defined", o.Font)
+	}
+	fp := defaultStringValue(o.FontPath, cfg.FontPath)
+	if len(fp) > 0 {
+		return fp, nil
+	}
+	if fp, ok := cfg.fonts.Path("default"); ok {
+		return fp, nil
+	}
+	return "", fmt.Errorf("no font specified for text overlay")
+}
+
+// textFont returns the parsed font for a text overlay.
+func (o *OverlayOpts) textFont(cfg *Config) (*truetype.Font, error) {
+	fp, err := o.fontFile(cfg)
+	if err != nil {
+		return nil, err
+	}
+	return cfg.fonts.Load(fp)
+}
+
+// textLayout returns the text box layout specified by the overlay options.
+func (o *OverlayOpts) textLayout() (text.Layout, error) {
+	l := text.Layout{
+		Width:      o.Width,
+		Height:     o.Height,
+		LineHeight: o.LineHeight,
+		Align:      strings.ToLower(o.Align),
+		VAlign:     strings.ToLower(o.VAlign),
+		Fit:        strings.ToLower(o.Fit),
+		MinSize:    float64(o.MinSize),
+		MaxSize:    float64(o.MaxSize),
+		MaxLines:   o.MaxLines,
+	}
+	switch l.Align {
+	case "", "left", "center", "right", "justify":
+	default:
+		return l, fmt.Errorf("%s is not a valid text alignment", o.Align)
+	}
+	switch l.VAlign {
+	case "", "top", "middle", "bottom":
+	default:
+		return l, fmt.Errorf("%s is not a valid vertical text alignment", o.VAlign)
+	}
+	switch l.Fit {
+	case "":
+	case "shrink", "grow", "both":
+		if l.Width <= 0 && l.Height <= 0 {
+			return l, fmt.Errorf("text fit requires a width and / or height")
+		}
+	default:
+		return l, fmt.Errorf("%s is not a valid text fit mode", o.Fit)
+	}
+	if l.Width < 0 || l.Height < 0 {
+		return l, fmt.Errorf("text box width and height must not be negative")
+	}
+	return l, nil
+}
+
+// qrOptions returns how the value of a qr overlay is encoded.
+func (o *OverlayOpts) qrOptions() (qr.Options, error) {
+	opts := qr.Options{
+		Level:      strings.ToLower(o.QRLevel),
+		QuietZone:  o.QRQuietZone,
+		MinVersion: o.QRMinVersion,
+		MaxVersion: o.QRMaxVersion,
+		Scale:      strings.ToLower(o.QRScale),
+		Center:     o.QRCenter,
+	}
+	return opts, opts.Validate()
+}
+
+// imageLayout returns the box that an image overlay is resized to.
+func (o *OverlayOpts) imageLayout() (image.Layout, error) {
+	l := image.Layout{
+		Width:   o.Width,
+		Height:  o.Height,
+		Fit:     strings.ToLower(o.Fit),
+		Gravity: strings.ToLower(o.Gravity),
+		Filter:  strings.ToLower(o.Filter),
+	}
+	return l, l.Validate()
+}
+
+// MaskOpts specifies the mask of an image overlay.  It is either the name of a
+// shape (circle, ellipse) or the path of a mask image, or a mapping with the
+// `shape` and its corner `radius` (for rounded rectangles) or the `image`.
+type MaskOpts struct {
+	Shape  string `yaml:"shape"`
+	Radius int    `yaml:"radius"`
+	Image  string `yaml:"image"`
+}
+
+// UnmarshalYAML allows masks to be specified as a plain shape name or image
+// path in the config.
+func (m *MaskOpts) UnmarshalYAML(unmarshal func(interface{}) error) error {
+	var s string
+	if err := unmarshal(&s); err == nil {
+		switch strings.ToLower(s) {
+		case image.MaskCircle, image.MaskEllipse, image.MaskRounded:
+			*m = MaskOpts{Shape: s}
+		default:
+			*m = MaskOpts{Image: s}
+		}
+		return nil
+	}
+
+	type plain MaskOpts
+	return unmarshal((*plain)(m))
+}
+
+// mask returns the validated mask, or nil if there is none.
+func (m *MaskOpts) mask() (*image.Mask, error) {
+	if m == nil {
+		return nil, nil
+	}
+	mask := &image.Mask{Shape: strings.ToLower(m.Shape), Radius: m.Radius, Path: m.Image}
+	return mask, mask.Validate()
+}
+
+////////////////////////////////////////////////////////////////////////////////
+
+// Output represents a single job to be done for a given background image, and
+// the list of overlays that are to be applied to the same.
+type Output struct {
+	Prefix     string          `yaml:"prefix"`
+	Filename   string          `yaml:"filename"` // template for each item's file name
+	Background string          `yaml:"background"`
+	Filters    []filter.Filter `yaml:"filters"` // applied to the background
+	Overlays   []*OverlayOpts  `yaml:"overlays"`
+	SinglePDF  bool            `yaml:"single_pdf"` // all items as pages of one pdf
+	Imposition *Imposition     `yaml:"imposition"` // pack items onto print sheets
+
+	layout *impose.Layout // (internal) resolved imposition layout
+}
+
+// writesItems returns true if each item is written to its own file.  Items
+// that are collated are only written to their own file if the imposition asks
+// for it.
+func (o *Output) writesItems() bool {
+	return !o.SinglePDF && (o.Imposition == nil || o.Imposition.Individual)
+}
+
+////////////////////////////////////////////////////////////////////////////////
+
+// CMYKOptions configure the conversion of the rendered images to CMYK, for
+// jpeg and tiff files in the cmyk colorspace.
+type CMYKOptions struct {
+	Black        *float64 `yaml:"black"`         // fraction of gray printed with black (default: 1)
+	InkLimit     float64  `yaml:"ink_limit"`     // total ink coverage in percent (default: 400)
+	EmbedProfile string   `yaml:"embed_profile"` // icc profile to tag the files with, not converted through
+}
+
+////////////////////////////////////////////////////////////////////////////////
+
+// Options control where and how the items of a config are rendered.  They are
+// usually set from the command line.
+type Options struct {
+	OutDir     string    // output directory
+	Backend    string    // renderer backend, overrides the config
+	MagickBins string    // path to the imagemagick binaries
+	Jobs       int       // number of items to render concurrently
+	KeepGoing  bool      // continue rendering other items on error
+	Verbose    bool      // log additional details for each overlay
+	Overwrite  string    // overwrite policy for existing output files
+	Resume     bool      // skip items whose output files are unchanged
+	Log        io.Writer // progress of the batch, discarded if nil
+}
+
+////////////////////////////////////////////////////////////////////////////////
+
+// Config represents the config file needed to run the program.
+type Config struct {
+	Backend      string                   `yaml:"backend"`
+	ColorSpace   string                   `yaml:"colorspace"`
+	CMYK         *CMYKOptions             `yaml:"cmyk"`
+	FontPath     string                   `yaml:"fontpath"`
+	Fonts        map[string]string        `yaml:"fonts"`
+	Context      map[string]interface{}   `yaml:"context"`
+	Items        []map[string]interface{} `yaml:"items"`
+	ItemsSource  *ItemsSource             `yaml:"items_source"`
+	Outputs      []*Output                `yaml:"outputs"`
+	OutputFormat string                   `yaml:"output_format"`
+	OutputDpi    int                      `yaml:"output_dpi"` // pdf page size
+
+	path     string            // config file that the config was loaded from
+	opts     Options           // options that the config was loaded with
+	log      *log.Logger       // progress of the batch
+	fonts    *text.Registry    // parsed fonts, shared by all items
+	cmyk     *cmyk.Converter   // converts images in the cmyk colorspace
+	backend  composite.Backend // builds the images of items that are not collated
+	modTime  time.Time         // newest of the config, items source and fonts
+	manifest *manifest         // files generated into the output directory
+	kept     map[string]bool   // pdf documents that are kept as they are
+	bgs      backgrounds       // decoded backgrounds, for items rendered on demand
+}
+
+// loadFonts registers the named fonts and parses each of them (and the global
+// font, if specified) so that missing or invalid fonts are reported before
+// any items are rendered.
+func (c *Config) loadFonts(ps *Problems) {
+	c.fonts = text.NewRegistry()
+	for name, fp := range c.Fonts {
+		c.fonts.Register(name, fp)
+		if _, err := c.fonts.Font(name); err != nil {
+			ps.add("fonts."+name, "%s", err.Error())
+		}
+	}
+	if len(c.FontPath) > 0 {
+		if _, err := c.fonts.Load(c.FontPath); err != nil {
+			ps.add("fontpath", "%s", err.Error())
+		}
+	}
+}
@@ -0,0 +1,105 @@
+////////////////////////////////////////////////////////////////////////////////
+
+package job
+
+////////////////////////////////////////////////////////////////////////////////
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/sabhiram/imagenie/composite/impose"
+)
+
+////////////////////////////////////////////////////////////////////////////////
+
+// sheetSizes are the named sheet sizes in portrait orientation (mm).
+var sheetSizes = map[string][2]float64{
+	"a3":      {297, 420},
+	"a4":      {210, 297},
+	"a5":      {148, 210},
+	"letter":  {215.9, 279.4},
+	"legal":   {215.9, 355.6},
+	"tabloid": {279.4, 431.8},
+}
+
+////////////////////////////////////////////////////////////////////////////////
+
+// Imposition specifies how the items of an output are packed onto print
+// sheets.  All lengths are in millimeters and are converted to pixels using
+// the config's `output_dpi`, which should match the resolution of the
+// background.
+type Imposition struct {
+	Sheet       string  `yaml:"sheet"`       // a3, a4, a5, letter, legal, tabloid
+	SheetWidth  float64 `yaml:"sheet_width"` // custom sheet size, overrides `sheet`
+	SheetHeight float64 `yaml:"sheet_height"`
+	Orientation string  `yaml:"orientation"` // portrait, landscape
+	Rows        int     `yaml:"rows"`
+	Columns     int     `yaml:"columns"`
+	Gutter      float64 `yaml:"gutter"`   // space between items in both directions
+	GutterX     float64 `yaml:"gutter_x"` // overrides `gutter` horizontally
+	GutterY     float64 `yaml:"gutter_y"` // overrides `gutter` vertically
+	Margin      float64 `yaml:"margin"`
+	Bleed       float64 `yaml:"bleed"` // part of each item outside its trim line
+	CropMarks   bool    `yaml:"crop_marks"`
+	RegMarks    bool    `yaml:"registration_marks"`
+	Format      string  `yaml:"format"`     // sheet format (default: output_format)
+	Individual  bool    `yaml:"individual"` // also write each item's file
+}
+
+// Layout converts the imposition into a sheet layout in pixels at `dpi`.
+func (m *Imposition) Layout(dpi int) (*impose.Layout, error) {
+	px := func(mm float64) int {
+		return int(mm/25.4*float64(dpi) + 0.5)
+	}
+
+	w, h := m.SheetWidth, m.SheetHeight
+	if w <= 0 || h <= 0 {
+		name := strings.ToLower(defaultStringValue(m.Sheet, "a4"))
+		size, ok := sheetSizes[name]
+		if !ok {
+			return nil, fmt.Errorf("imposition: %s is not a known sheet size", m.Sheet)
+		}
+		w, h = size[0], size[1]
+	}
+	switch strings.ToLower(m.Orientation) {
+	case "", "portrait":
+		if w > h {
+			w, h = h, w
+		}
+	case "landscape":
+		if w < h {
+			w, h = h, w
+		}
+	default:
+		return nil, fmt.Errorf("imposition: %s is not a valid orientation", m.Orientation)
+	}
+
+	gx, gy := m.Gutter, m.Gutter
+	if m.GutterX > 0 {
+		gx = m.GutterX
+	}
+	if m.GutterY > 0 {
+		gy = m.GutterY
+	}
+
+	// Crop marks are 5mm long and start 1mm outside of the bleed, and are
+	// drawn with a 0.25pt line.
+	return &impose.Layout{
+		SheetWidth:        px(w),
+		SheetHeight:       px(h),
+		Rows:              m.Rows,
+		Columns:           m.Columns,
+		GutterX:           px(gx),
+		GutterY:           px(gy),
+		Margin:            px(m.Margin),
+		Bleed:             px(m.Bleed),
+		CropMarks:         m.CropMarks,
+		RegistrationMarks: m.RegMarks,
+		MarkLength:        px(5),
+		MarkOffset:        px(m.Bleed + 1),
+		MarkWeight:        int(0.25/72*float64(dpi) + 0.5),
+	}, nil
+}
+
+////////////////////////////////////////////////////////////////////////////////
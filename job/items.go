@@ -0,0 +1,305 @@
+////////////////////////////////////////////////////////////////////////////////
+
+package job
+
+////////////////////////////////////////////////////////////////////////////////
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"text/template"
+)
+
+////////////////////////////////////////////////////////////////////////////////
+
+// ItemsSource specifies an external file to load items from, in addition to
+// (or instead of) the items specified inline in the config.
+type ItemsSource struct {
+	Path       string            `yaml:"path"`        // path to the file to load
+	Format     string            `yaml:"format"`      // csv, json, jsonl (default: from extension)
+	Delimiter  string            `yaml:"delimiter"`   // csv field delimiter (default: ",")
+	RawStrings bool              `yaml:"raw_strings"` // disable csv type inference
+	Rename     map[string]string `yaml:"rename"`      // column / key renames (old: new)
+	Filter     string            `yaml:"filter"`      // template that must evaluate to "true" to keep an item
+	Combine    string            `yaml:"combine"`     // append, prepend, replace (default: append)
+}
+
+// Load reads the items from the source file, renames keys and filters them
+// against the specified global context.
+func (s *ItemsSource) Load(global map[string]interface{}) ([]map[string]interface{}, error) {
+	if len(s.Path) == 0 {
+		return nil, fmt.Errorf("items_source: path must be specified")
+	}
+	if err := s.checkRename(); err != nil {
+		return nil, err
+	}
+
+	fd, err := os.Open(s.Path)
+	if err != nil {
+		return nil, err
+	}
+	defer fd.Close()
+
+	format := strings.ToLower(s.Format)
+	if len(format) == 0 {
+		format = strings.TrimPrefix(strings.ToLower(filepath.Ext(s.Path)), ".")
+	}
+
+	var items []map[string]interface{}
+	switch format {
+	case "csv", "tsv":
+		items, err = s.readCSV(fd, format)
+	case "json":
+		items, err = readJSON(fd)
+	case "jsonl", "ndjson":
+		items, err = readJSONLines(fd)
+	default:
+		return nil, fmt.Errorf("items_source: %s is not a valid format", format)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("items_source: %s: %s", s.Path, err.Error())
+	}
+
+	for _, item := range items {
+		s.rename(item)
+	}
+
+	if len(s.Filter) == 0 {
+		return items, nil
+	}
+	return s.filter(items, global)
+}
+
+// checkRename returns an error if two keys are renamed to the same key, as only
+// one of their values could be kept.
+func (s *ItemsSource) checkRename() error {
+	froms := make([]string, 0, len(s.Rename))
+	for from := range s.Rename {
+		froms = append(froms, from)
+	}
+	sort.Strings(froms)
+
+	seen := map[string]string{}
+	for _, from := range froms {
+		to := s.Rename[from]
+		if prev, ok := seen[to]; ok {
+			return fmt.Errorf("items_source: rename: %s and %s are both renamed to %s", prev, from, to)
+		}
+		seen[to] = from
+	}
+	return nil
+}
+
+// rename renames the keys of the item all at once, so that renames can swap
+// or chain keys (`{a: b, b: a}`) regardless of the order they are applied in.
+func (s *ItemsSource) rename(item map[string]interface{}) {
+	values := map[string]interface{}{}
+	for from, to := range s.Rename {
+		if v, ok := item[from]; ok {
+			values[to] = v
+		}
+	}
+	for from := range s.Rename {
+		delete(item, from)
+	}
+	for to, v := range values {
+		item[to] = v
+	}
+}
+
+// filter returns the items for which the filter template evaluates to "true"
+// when executed against the item merged with the global context.
+func (s *ItemsSource) filter(items []map[string]interface{}, global map[string]interface{}) ([]map[string]interface{}, error) {
+	t, err := template.New("filter").Funcs(funcMap).Parse(s.Filter)
+	if err != nil {
+		return nil, fmt.Errorf("items_source: unable to parse filter: %s", err.Error())
+	}
+
+	kept := []map[string]interface{}{}
+	for idx, item := range items {
+		var buf bytes.Buffer
+		if err := t.Execute(&buf, buildContext(global, item)); err != nil {
+			return nil, fmt.Errorf("items_source: unable to filter item #%d: %s", idx+1, err.Error())
+		}
+		if strings.TrimSpace(buf.String()) == "true" {
+			kept = append(kept, item)
+		}
+	}
+	return kept, nil
+}
+
+// Merge combines the loaded items with the inline items from the config.
+func (s *ItemsSource) Merge(inline, loaded []map[string]interface{}) ([]map[string]interface{}, error) {
+	switch strings.ToLower(s.Combine) {
+	case "", "append":
+		return append(inline, loaded...), nil
+	case "prepend":
+		return append(loaded, inline...), nil
+	case "replace":
+		return loaded, nil
+	}
+	return nil, fmt.Errorf("items_source: %s is not a valid combine mode", s.Combine)
+}
+
+////////////////////////////////////////////////////////////////////////////////
+
+// readCSV reads the records from a csv file, using the header row as the keys
+// for each item.
+func (s *ItemsSource) readCSV(r io.Reader, format string) ([]map[string]interface{}, error) {
+	cr := csv.NewReader(r)
+	if format == "tsv" {
+		cr.Comma = '\t'
+	}
+	if len(s.Delimiter) > 0 {
+		d := []rune(s.Delimiter)
+		if len(d) != 1 {
+			return nil, fmt.Errorf("delimiter must be a single character")
+		}
+		cr.Comma = d[0]
+	}
+
+	header, err := cr.Read()
+	if err == io.EOF {
+		return nil, fmt.Errorf("missing header row")
+	} else if err != nil {
+		return nil, err
+	}
+	for i := range header {
+		header[i] = strings.TrimSpace(header[i])
+	}
+
+	items := []map[string]interface{}{}
+	for {
+		record, err := cr.Read()
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			return nil, err
+		}
+
+		item := make(map[string]interface{}, len(header))
+		for i, key := range header {
+			if i >= len(record) {
+				break
+			}
+			if s.RawStrings {
+				item[key] = record[i]
+			} else {
+				item[key] = inferValue(record[i])
+			}
+		}
+		items = append(items, item)
+	}
+	return items, nil
+}
+
+// inferValue converts a csv field into an int, float or bool where it is
+// unambiguous to do so.  Numbers with leading zeros (like zip codes or
+// employee ids) are left as strings.
+func inferValue(s string) interface{} {
+	t := strings.TrimSpace(s)
+	if len(t) == 0 {
+		return s
+	}
+
+	digits := strings.TrimLeft(t, "+-")
+	if len(digits) > 1 && digits[0] == '0' && digits[1] != '.' {
+		return s
+	}
+	if len(digits) > 0 && (digits[0] == '.' || (digits[0] >= '0' && digits[0] <= '9')) {
+		if i, err := strconv.Atoi(t); err == nil {
+			return i
+		}
+		if f, err := strconv.ParseFloat(t, 64); err == nil {
+			return f
+		}
+	}
+	switch strings.ToLower(t) {
+	case "true":
+		return true
+	case "false":
+		return false
+	}
+	return s
+}
+
+////////////////////////////////////////////////////////////////////////////////
+
+// readJSON reads a json array of objects.
+func readJSON(r io.Reader) ([]map[string]interface{}, error) {
+	dec := json.NewDecoder(r)
+	dec.UseNumber()
+
+	var items []map[string]interface{}
+	if err := dec.Decode(&items); err != nil {
+		return nil, err
+	}
+	for _, item := range items {
+		normalizeNumbers(item)
+	}
+	return items, nil
+}
+
+// readJSONLines reads one json object per line, ignoring blank lines.
+func readJSONLines(r io.Reader) ([]map[string]interface{}, error) {
+	items := []map[string]interface{}{}
+
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 64*1024), 16*1024*1024)
+	for line := 1; scanner.Scan(); line++ {
+		raw := bytes.TrimSpace(scanner.Bytes())
+		if len(raw) == 0 {
+			continue
+		}
+
+		dec := json.NewDecoder(bytes.NewReader(raw))
+		dec.UseNumber()
+
+		var item map[string]interface{}
+		if err := dec.Decode(&item); err != nil {
+			return nil, fmt.Errorf("line %d: %s", line, err.Error())
+		}
+		normalizeNumbers(item)
+		items = append(items, item)
+	}
+	return items, scanner.Err()
+}
+
+// normalizeNumbers converts json numbers into ints where possible and floats
+// otherwise, to match the types produced for inline yaml items.
+func normalizeNumbers(m map[string]interface{}) {
+	for k, v := range m {
+		m[k] = normalizeNumber(v)
+	}
+}
+
+// normalizeNumber returns the json value with its numbers converted, including
+// those nested in objects and arrays.
+func normalizeNumber(v interface{}) interface{} {
+	switch tv := v.(type) {
+	case json.Number:
+		if i, err := tv.Int64(); err == nil {
+			return int(i)
+		} else if f, err := tv.Float64(); err == nil {
+			return f
+		}
+	case map[string]interface{}:
+		normalizeNumbers(tv)
+	case []interface{}:
+		for i := range tv {
+			tv[i] = normalizeNumber(tv[i])
+		}
+	}
+	return v
+}
+
+////////////////////////////////////////////////////////////////////////////////
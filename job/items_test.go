@@ -0,0 +1,146 @@
+package job
+
+////////////////////////////////////////////////////////////////////////////////
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+////////////////////////////////////////////////////////////////////////////////
+
+func TestInferValue(t *testing.T) {
+	for _, c := range []struct {
+		in       string
+		expected interface{}
+	}{
+		{"", ""},
+		{" ", " "},
+		{"0", 0},
+		{"42", 42},
+		{" 42 ", 42},
+		{"+5", 5},
+		{"-5", -5},
+		{"-0", 0},
+		{"007", "007"},
+		{"-007", "-007"},
+		{"0.5", 0.5},
+		{".5", 0.5},
+		{"-0.5", -0.5},
+		{"+.5", 0.5},
+		{"1e5", 1e5},
+		{"1.5E-3", 1.5e-3},
+		{"1e", "1e"},
+		{"1,000", "1,000"},
+		{"12abc", "12abc"},
+		{"Inf", "Inf"},
+		{"NaN", "NaN"},
+		{"true", true},
+		{"TRUE", true},
+		{"False", false},
+		{"yes", "yes"},
+		{"-", "-"},
+	} {
+		if got := inferValue(c.in); got != c.expected {
+			t.Errorf("%q: expected %#v, got %#v", c.in, c.expected, got)
+		}
+	}
+}
+
+func TestRename(t *testing.T) {
+	for _, c := range []struct {
+		name     string
+		rename   map[string]string
+		item     map[string]interface{}
+		expected map[string]interface{}
+	}{
+		{
+			"simple",
+			map[string]string{"a": "x"},
+			map[string]interface{}{"a": 1, "b": 2},
+			map[string]interface{}{"x": 1, "b": 2},
+		},
+		{
+			"swap",
+			map[string]string{"a": "b", "b": "a"},
+			map[string]interface{}{"a": 1, "b": 2},
+			map[string]interface{}{"a": 2, "b": 1},
+		},
+		{
+			"chain",
+			map[string]string{"a": "b", "b": "c"},
+			map[string]interface{}{"a": 1, "b": 2, "c": 3},
+			map[string]interface{}{"b": 1, "c": 2},
+		},
+		{
+			"cycle",
+			map[string]string{"a": "b", "b": "c", "c": "a"},
+			map[string]interface{}{"a": 1, "b": 2, "c": 3},
+			map[string]interface{}{"a": 3, "b": 1, "c": 2},
+		},
+		{
+			"target only",
+			map[string]string{"a": "b"},
+			map[string]interface{}{"b": 2},
+			map[string]interface{}{"b": 2},
+		},
+		{
+			"overwrite",
+			map[string]string{"a": "b"},
+			map[string]interface{}{"a": 1, "b": 2},
+			map[string]interface{}{"b": 1},
+		},
+	} {
+		s := &ItemsSource{Rename: c.rename}
+		if err := s.checkRename(); err != nil {
+			t.Errorf("%s: %s", c.name, err.Error())
+		}
+		s.rename(c.item)
+		if !reflect.DeepEqual(c.item, c.expected) {
+			t.Errorf("%s: expected %v, got %v", c.name, c.expected, c.item)
+		}
+	}
+}
+
+func TestCheckRename(t *testing.T) {
+	s := &ItemsSource{Rename: map[string]string{"b": "x", "c": "y", "a": "x"}}
+	err := s.checkRename()
+	if err == nil {
+		t.Fatal("expected an error for two keys renamed to x")
+	}
+	if expected := "a and b are both renamed to x"; !strings.Contains(err.Error(), expected) {
+		t.Errorf("expected %q in %q", expected, err.Error())
+	}
+}
+
+func TestReadJSONNumbers(t *testing.T) {
+	expected := []map[string]interface{}{
+		{
+			"n": 1,
+			"f": 1.5,
+			"s": "2",
+			"o": map[string]interface{}{"n": 3, "a": []interface{}{4, 4.5}},
+			"a": []interface{}{5, "6", []interface{}{7}, map[string]interface{}{"n": 8}},
+		},
+	}
+	const item = `{"n": 1, "f": 1.5, "s": "2", "o": {"n": 3, "a": [4, 4.5]}, "a": [5, "6", [7], {"n": 8}]}`
+
+	items, err := readJSON(strings.NewReader("[" + item + "]"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(items, expected) {
+		t.Errorf("json: expected %v, got %#v", expected, items)
+	}
+
+	items, err = readJSONLines(strings.NewReader("\n" + item + "\n\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(items, expected) {
+		t.Errorf("jsonl: expected %v, got %#v", expected, items)
+	}
+}
+
+////////////////////////////////////////////////////////////////////////////////
@@ -0,0 +1,226 @@
+////////////////////////////////////////////////////////////////////////////////
+
+package job
+
+////////////////////////////////////////////////////////////////////////////////
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path"
+	"sort"
+	"sync"
+
+	"gopkg.in/yaml.v2"
+
+	"github.com/sabhiram/imagenie/composite"
+)
+
+////////////////////////////////////////////////////////////////////////////////
+
+// manifestName is the name of the manifest file in the output directory.
+const manifestName = "imagenie-manifest.json"
+
+// manifestEntry records a generated file, and a hash of everything that it was
+// built from.  Files that are collated from several items (single pdfs and
+// sheets) list all of them, and their input hash covers each of them.
+type manifestEntry struct {
+	Path       string `json:"path"`
+	Output     string `json:"output"`
+	Item       int    `json:"item,omitempty"`  // 1 based, as in the logs
+	Items      []int  `json:"items,omitempty"` // collated files only
+	InputHash  string `json:"input_hash"`
+	OutputHash string `json:"output_hash"`
+}
+
+// manifest is the set of files generated by the runs into an output directory.
+// Entries for files that are not generated by a run are kept as is.  It is
+// safe for concurrent use.
+type manifest struct {
+	mu      sync.Mutex
+	entries map[string]*manifestEntry // file path -> entry
+	hashes  map[string]string         // input file path -> hash, for this run
+	outputs map[*Output]string        // hash of each output's definition
+}
+
+// loadManifest reads the manifest at `fp`.  A missing manifest is empty.
+func loadManifest(fp string) (*manifest, error) {
+	m := &manifest{
+		entries: map[string]*manifestEntry{},
+		hashes:  map[string]string{},
+		outputs: map[*Output]string{},
+	}
+
+	data, err := ioutil.ReadFile(fp)
+	if os.IsNotExist(err) {
+		return m, nil
+	} else if err != nil {
+		return nil, err
+	}
+
+	entries := []*manifestEntry{}
+	if err := json.Unmarshal(data, &entries); err != nil {
+		return nil, fmt.Errorf("unable to parse manifest %s: %s", fp, err.Error())
+	}
+	for _, e := range entries {
+		m.entries[e.Path] = e
+	}
+	return m, nil
+}
+
+// save writes the manifest to `fp`, with its entries sorted by path.
+func (m *manifest) save(fp string) error {
+	m.mu.Lock()
+	entries := make([]*manifestEntry, 0, len(m.entries))
+	for _, e := range m.entries {
+		entries = append(entries, e)
+	}
+	m.mu.Unlock()
+	sort.Slice(entries, func(i, j int) bool {
+		return entries[i].Path < entries[j].Path
+	})
+
+	fd, err := composite.CreateAtomic(fp)
+	if err != nil {
+		return err
+	}
+	enc := json.NewEncoder(fd)
+	enc.SetIndent("", "  ")
+	if err := enc.Encode(entries); err != nil {
+		fd.Abort()
+		return err
+	}
+	return fd.Commit()
+}
+
+// record hashes the file at `e.Path`, which has just been written, and adds its
+// entry to the manifest.
+func (m *manifest) record(e *manifestEntry) error {
+	h, err := hashFile(e.Path)
+	if err != nil {
+		return err
+	}
+	e.OutputHash = h
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.entries[e.Path] = e
+	return nil
+}
+
+// unchanged returns true if the file at `fp` was generated from inputs with the
+// same hash, and has not been modified since.
+func (m *manifest) unchanged(fp, inputHash string) bool {
+	m.mu.Lock()
+	e, ok := m.entries[fp]
+	m.mu.Unlock()
+	if !ok || e.InputHash != inputHash {
+		return false
+	}
+	h, err := hashFile(fp)
+	return err == nil && h == e.OutputHash
+}
+
+////////////////////////////////////////////////////////////////////////////////
+
+// inputHash returns a hash of everything that an item of the output is built
+// from: the global settings, the output's definition, the item's context and
+// the contents of the background, images and fonts that it uses.
+func (m *manifest) inputHash(cfg *Config, output *Output, ctxt map[string]interface{}) (string, error) {
+	def, err := m.outputHash(cfg, output)
+	if err != nil {
+		return "", err
+	}
+	data, err := yaml.Marshal(ctxt)
+	if err != nil {
+		return "", err
+	}
+
+	h := sha256.New()
+	fmt.Fprintf(h, "output %s\n", def)
+	fmt.Fprintf(h, "context %x\n", sha256.Sum256(data))
+	for _, fp := range cfg.inputFiles(output, ctxt) {
+		fmt.Fprintf(h, "file %q %s\n", fp, m.fileHash(fp))
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// outputHash returns a hash of the output's definition and the global settings
+// that affect how it is rendered.
+func (m *manifest) outputHash(cfg *Config, output *Output) (string, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if h, ok := m.outputs[output]; ok {
+		return h, nil
+	}
+
+	data, err := yaml.Marshal(struct {
+		Format     string
+		Dpi        int
+		ColorSpace string
+		CMYK       *CMYKOptions
+		Backend    string
+		Output     *Output
+	}{cfg.OutputFormat, cfg.OutputDpi, cfg.ColorSpace, cfg.CMYK, cfg.Backend, output})
+	if err != nil {
+		return "", err
+	}
+	h := fmt.Sprintf("%x", sha256.Sum256(data))
+	m.outputs[output] = h
+	return h, nil
+}
+
+// fileHash returns the (cached) hash of an input file.  Files that cannot be
+// read hash to "-", they are reported elsewhere.
+func (m *manifest) fileHash(fp string) string {
+	m.mu.Lock()
+	h, ok := m.hashes[fp]
+	m.mu.Unlock()
+	if ok {
+		return h
+	}
+
+	h, err := hashFile(fp)
+	if err != nil {
+		h = "-"
+	}
+	m.mu.Lock()
+	m.hashes[fp] = h
+	m.mu.Unlock()
+	return h
+}
+
+// collatedHash combines the input hashes of the items of a collated file.
+func collatedHash(hashes []string) string {
+	h := sha256.New()
+	for _, ih := range hashes {
+		io.WriteString(h, ih+"\n")
+	}
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+func hashFile(fp string) (string, error) {
+	fd, err := os.Open(fp)
+	if err != nil {
+		return "", err
+	}
+	defer fd.Close()
+
+	h := sha256.New()
+	if _, err := io.Copy(h, fd); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// manifestPath returns the path of the manifest in the output directory.
+func (c *Config) manifestPath() string {
+	return path.Join(c.opts.OutDir, manifestName)
+}
+
+////////////////////////////////////////////////////////////////////////////////
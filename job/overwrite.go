@@ -0,0 +1,94 @@
+////////////////////////////////////////////////////////////////////////////////
+
+package job
+
+////////////////////////////////////////////////////////////////////////////////
+
+import (
+	"os"
+	"time"
+)
+
+////////////////////////////////////////////////////////////////////////////////
+
+// Overwrite policies for output files that already exist.
+const (
+	OverwriteAlways  = "always"   // always replace the file
+	OverwriteNever   = "never"    // never replace the file
+	OverwriteIfNewer = "if-newer" // replace the file if any of its inputs are newer
+)
+
+// shouldWrite returns true if the file at `fp` is to be written, according to
+// the overwrite policy.  The `newest` time is that of the newest input that
+// the file is built from.
+func (c *Config) shouldWrite(fp string, newest time.Time) (bool, error) {
+	if c.opts.Overwrite == OverwriteAlways {
+		return true, nil
+	}
+	fi, err := os.Stat(fp)
+	if os.IsNotExist(err) {
+		return true, nil
+	} else if err != nil {
+		return false, err
+	}
+	if c.opts.Overwrite == OverwriteNever {
+		return false, nil
+	}
+	return newest.After(fi.ModTime()), nil
+}
+
+// newestFile returns the later of `t` and the modification time of each of the
+// files.  Files that cannot be read are ignored, they are reported elsewhere.
+func newestFile(t time.Time, paths ...string) time.Time {
+	for _, fp := range paths {
+		if fi, err := os.Stat(fp); err == nil && fi.ModTime().After(t) {
+			t = fi.ModTime()
+		}
+	}
+	return t
+}
+
+////////////////////////////////////////////////////////////////////////////////
+
+// configTime returns the modification time of the newest of the config file,
+// its items source and its fonts.
+func (c *Config) configTime(fp string) time.Time {
+	paths := []string{fp, c.FontPath}
+	if c.ItemsSource != nil {
+		paths = append(paths, c.ItemsSource.Path)
+	}
+	for _, font := range c.Fonts {
+		paths = append(paths, font)
+	}
+	return newestFile(time.Time{}, paths...)
+}
+
+// inputTime returns the modification time of the newest file that an item of
+// the output is built from.
+func (c *Config) inputTime(output *Output, ctxt map[string]interface{}) time.Time {
+	return newestFile(c.modTime, c.inputFiles(output, ctxt)...)
+}
+
+// inputFiles returns the files that an item of the output is built from: the
+// background and the images and fonts of its overlays.
+func (c *Config) inputFiles(output *Output, ctxt map[string]interface{}) []string {
+	files := []string{output.Background}
+	for _, o := range output.Overlays {
+		switch o.Type {
+		case "image":
+			if tv, err := o.value(ctxt); err == nil {
+				files = append(files, tv)
+			}
+			if o.Mask != nil && len(o.Mask.Image) > 0 {
+				files = append(files, o.Mask.Image)
+			}
+		case "text":
+			if fp, err := o.fontFile(c); err == nil {
+				files = append(files, fp)
+			}
+		}
+	}
+	return files
+}
+
+////////////////////////////////////////////////////////////////////////////////
@@ -0,0 +1,175 @@
+////////////////////////////////////////////////////////////////////////////////
+
+package job
+
+////////////////////////////////////////////////////////////////////////////////
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/sabhiram/imagenie/composite/qr"
+)
+
+////////////////////////////////////////////////////////////////////////////////
+
+// PlanFile is a file that an item is written to.  Items that are collated into
+// a pdf are written to a page of the file.
+type PlanFile struct {
+	Path string `json:"path"`
+	Page int    `json:"page,omitempty"`
+}
+
+// PlanOverlay is the resolved value of an overlay for an item, along with the
+// font or image file that it uses, or the size that a qr code is rendered at.
+type PlanOverlay struct {
+	Type  string `json:"type"`
+	Value string `json:"value"`
+	Font  string `json:"font,omitempty"`
+	Image string `json:"image,omitempty"`
+	Size  int    `json:"size,omitempty"`
+}
+
+// PlanEntry describes what would be produced for a single item of an output.
+type PlanEntry struct {
+	Output     string         `json:"output"`
+	Item       int            `json:"item"` // 1 based, as in the logs
+	Background string         `json:"background"`
+	Files      []*PlanFile    `json:"files"`
+	Overlays   []*PlanOverlay `json:"overlays"`
+	Error      string         `json:"error,omitempty"`
+}
+
+////////////////////////////////////////////////////////////////////////////////
+
+// Plan walks every (output, item) pair, and resolves the overlays of each
+// without rendering anything.  Items whose overlays cannot be resolved record
+// the error in their entry.
+func Plan(cfg *Config) []*PlanEntry {
+	entries := []*PlanEntry{}
+	for _, output := range cfg.Outputs {
+		sheetErr := checkSheet(output)
+		for index, item := range cfg.Items {
+			e := planItem(cfg, output, index, item)
+			if len(e.Error) == 0 && sheetErr != nil {
+				e.Error = sheetErr.Error()
+			}
+			entries = append(entries, e)
+		}
+	}
+	return entries
+}
+
+// planItem resolves the overlays of the item at `index` of the output.
+func planItem(cfg *Config, output *Output, index int, item map[string]interface{}) *PlanEntry {
+	e := &PlanEntry{
+		Output:     output.Prefix,
+		Item:       index + 1,
+		Background: output.Background,
+		Overlays:   []*PlanOverlay{},
+	}
+
+	var err error
+	if e.Files, err = planFiles(cfg, output, index, item); err != nil {
+		e.Error = err.Error()
+		return e
+	}
+
+	ctxt := buildContext(cfg.Context, item)
+	for idx, overlay := range output.Overlays {
+		if _, err := overlay.GetRenderable(ctxt, cfg); err != nil {
+			e.Error = fmt.Sprintf("unable to get renderable for overlay %d: %s", idx+1, err.Error())
+			return e
+		}
+
+		// The renderable does not expose what it was built from, so the
+		// value is resolved once more (which cannot fail at this point).
+		tv, _ := overlay.value(ctxt)
+		po := &PlanOverlay{Type: overlay.Type, Value: tv}
+		switch overlay.Type {
+		case "text":
+			po.Font, _ = overlay.fontFile(cfg)
+		case "image":
+			po.Image = tv
+		case "qr":
+			opts, _ := overlay.qrOptions()
+			if po.Size, err = qr.Size(tv, opts, defaultIntValue(overlay.Size, 12)); err != nil {
+				e.Error = fmt.Sprintf("unable to encode overlay %d: %s", idx+1, err.Error())
+				return e
+			}
+		}
+		e.Overlays = append(e.Overlays, po)
+	}
+	return e
+}
+
+// planFiles returns the files that the item at `index` of the output is
+// written to, as in `renderItem` and the collator.
+func planFiles(cfg *Config, output *Output, index int, item map[string]interface{}) ([]*PlanFile, error) {
+	files := []*PlanFile{}
+	imposed := output.Imposition != nil
+	if output.writesItems() {
+		fp, err := itemPath(cfg, output, index, item)
+		if err != nil {
+			return nil, err
+		}
+		files = append(files, &PlanFile{Path: fp})
+	}
+	if output.SinglePDF {
+		files = append(files, &PlanFile{Path: pdfPath(cfg, output), Page: index + 1})
+	}
+	if imposed && output.layout != nil && output.layout.PerSheet() > 0 {
+		sheet := index / output.layout.PerSheet()
+		f := &PlanFile{Path: sheetPath(cfg, output, sheet)}
+		if sheetFormat(cfg, output) == "pdf" {
+			f.Page = sheet + 1
+		}
+		files = append(files, f)
+	}
+	return files, nil
+}
+
+////////////////////////////////////////////////////////////////////////////////
+
+// WritePlanJSON writes the plan as an indented json array of entries.
+func WritePlanJSON(w io.Writer, entries []*PlanEntry) error {
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(entries)
+}
+
+// WritePlanTable writes the plan as a table with a row for the background and
+// each overlay of every item.
+func WritePlanTable(w io.Writer, entries []*PlanEntry) error {
+	escape := strings.NewReplacer("\n", `\n`, "\t", `\t`)
+
+	tw := tabwriter.NewWriter(w, 0, 8, 2, ' ', 0)
+	fmt.Fprintln(tw, "OUTPUT\tITEM\tFILE\tOVERLAY\tVALUE\tFONT / IMAGE / SIZE")
+	for _, e := range entries {
+		files := []string{}
+		for _, f := range e.Files {
+			if f.Page > 0 {
+				files = append(files, fmt.Sprintf("%s (page %d)", f.Path, f.Page))
+			} else {
+				files = append(files, f.Path)
+			}
+		}
+		fmt.Fprintf(tw, "%s\t%d\t%s\tbackground\t\t%s\n", e.Output, e.Item, strings.Join(files, ", "), e.Background)
+		for _, o := range e.Overlays {
+			detail := o.Font + o.Image
+			if o.Size > 0 {
+				detail = fmt.Sprintf("%dx%d px", o.Size, o.Size)
+			}
+			fmt.Fprintf(tw, "\t\t\t%s\t%s\t%s\n", o.Type, escape.Replace(o.Value), detail)
+		}
+		if len(e.Error) > 0 {
+			fmt.Fprintf(tw, "\t\t\t!!!\t%s\t\n", e.Error)
+		}
+	}
+	return tw.Flush()
+}
+
+////////////////////////////////////////////////////////////////////////////////
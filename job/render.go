@@ -0,0 +1,159 @@
+////////////////////////////////////////////////////////////////////////////////
+
+package job
+
+////////////////////////////////////////////////////////////////////////////////
+
+import (
+	"context"
+	"fmt"
+	"image"
+	"io"
+	"io/ioutil"
+	"log"
+	"sync"
+
+	"github.com/sabhiram/imagenie/composite"
+)
+
+////////////////////////////////////////////////////////////////////////////////
+
+// ItemError is the error for an item of an output that failed to render.
+type ItemError struct {
+	Output string // prefix of the output
+	Item   int    // 1 based, as in the logs
+	Err    error
+}
+
+func (e *ItemError) Error() string {
+	return fmt.Sprintf("item #%d of output %s: %s", e.Item, e.Output, e.Err.Error())
+}
+
+func (e *ItemError) Unwrap() error {
+	return e.Err
+}
+
+// FileError is the error for a file that is collated from several items (a
+// single pdf or an imposed sheet) that failed to be written.
+type FileError struct {
+	Output string // prefix of the output
+	Path   string
+	Err    error
+}
+
+func (e *FileError) Error() string {
+	return fmt.Sprintf("%s: %s", e.Path, e.Err.Error())
+}
+
+func (e *FileError) Unwrap() error {
+	return e.Err
+}
+
+// BatchError is returned by `Run` if any items or files failed.  The errors
+// are `*ItemError`s and `*FileError`s, in the order they occurred.
+type BatchError struct {
+	Total  int // number of items in the batch
+	Errors []error
+}
+
+func (e *BatchError) Error() string {
+	return fmt.Sprintf("%d of %d item(s) failed to render", len(e.Errors), e.Total)
+}
+
+////////////////////////////////////////////////////////////////////////////////
+
+// Output returns the output with the specified prefix, or nil if there is
+// none.
+func (c *Config) Output(prefix string) *Output {
+	for _, output := range c.Outputs {
+		if output.Prefix == prefix {
+			return output
+		}
+	}
+	return nil
+}
+
+// backgrounds caches the decoded (and filtered) background of each output, so
+// that items rendered on demand do not decode it every time.  It is safe for
+// concurrent use.
+type backgrounds struct {
+	mu     sync.Mutex
+	images map[*Output]image.Image
+}
+
+// background returns the decoded background image of the output.
+func (c *Config) background(output *Output) (image.Image, error) {
+	c.bgs.mu.Lock()
+	img, ok := c.bgs.images[output]
+	c.bgs.mu.Unlock()
+	if ok {
+		return img, nil
+	}
+
+	img, err := composite.LoadBackground(output.Background, output.Filters)
+	if err != nil {
+		return nil, err
+	}
+	c.bgs.mu.Lock()
+	defer c.bgs.mu.Unlock()
+	if c.bgs.images == nil {
+		c.bgs.images = map[*Output]image.Image{}
+	}
+	c.bgs.images[output] = img
+	return img, nil
+}
+
+// LoadBackgrounds decodes the background of every output ahead of rendering
+// items with `Render` or `Encode`, which would otherwise decode each of them
+// on first use.
+func (c *Config) LoadBackgrounds() error {
+	for _, output := range c.Outputs {
+		if _, err := c.background(output); err != nil {
+			return fmt.Errorf("output %s: %s", output.Prefix, err.Error())
+		}
+	}
+	return nil
+}
+
+// Render composites the overlays of the output for the item onto its
+// background, and returns the image.  The item is merged with the config's
+// context exactly like the items of the config.  Items are always rendered
+// natively, and nothing is written to the output directory.
+func Render(ctx context.Context, cfg *Config, output *Output, item map[string]interface{}) (image.Image, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	renderables, err := cfg.renderables(output, buildContext(cfg.Context, item), log.New(ioutil.Discard, "", 0))
+	if err != nil {
+		return nil, err
+	}
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	bg, err := cfg.background(output)
+	if err != nil {
+		return nil, fmt.Errorf("unable to load background: %s", err.Error())
+	}
+	img, err := composite.RenderOnto(bg, renderables)
+	if err != nil {
+		return nil, fmt.Errorf("unable to build image: %s", err.Error())
+	}
+	return img, nil
+}
+
+// Encode renders the item as in `Render`, and writes the image to `w` in the
+// config's output format and colorspace.
+func Encode(ctx context.Context, cfg *Config, output *Output, item map[string]interface{}, w io.Writer) error {
+	return EncodeAs(ctx, cfg, output, item, cfg.OutputFormat, w)
+}
+
+// EncodeAs is like `Encode`, but writes the image in the specified format.
+func EncodeAs(ctx context.Context, cfg *Config, output *Output, item map[string]interface{}, offmt string, w io.Writer) error {
+	img, err := Render(ctx, cfg, output, item)
+	if err != nil {
+		return err
+	}
+	return composite.EncodeImage(w, cfg.printImage(img, offmt), offmt, float64(cfg.OutputDpi))
+}
+
+////////////////////////////////////////////////////////////////////////////////
@@ -0,0 +1,746 @@
+////////////////////////////////////////////////////////////////////////////////
+
+package job
+
+////////////////////////////////////////////////////////////////////////////////
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"image"
+	"log"
+	"os"
+	"path"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/sabhiram/imagenie/composite"
+	"github.com/sabhiram/imagenie/composite/impose"
+	"github.com/sabhiram/imagenie/composite/pdf"
+)
+
+////////////////////////////////////////////////////////////////////////////////
+
+// The manifest is saved after this many items are emitted, or this long after
+// it was last saved, so that a batch that is killed part way can be resumed.
+var (
+	manifestSaveItems    = 100
+	manifestSaveInterval = 10 * time.Second
+)
+
+// renderTask is a single (output, item) pair to be rendered.  The `seq` is the
+// position of the task in the overall batch and is used to order the logs.
+type renderTask struct {
+	seq    int
+	output *Output
+	index  int
+	item   map[string]interface{}
+}
+
+// renderResult captures the buffered log lines and error (if any) for a task.
+// Tasks for outputs that span multiple items (a single pdf, or imposed sheets)
+// produce a page and / or image, which are collated in item order.
+type renderResult struct {
+	seq       int
+	logs      bytes.Buffer
+	inputHash string
+	inputTime time.Time // newest file the item is built from
+	page      *pdf.Page
+	img       *image.RGBA
+	err       error
+}
+
+////////////////////////////////////////////////////////////////////////////////
+
+// buildContext merges the item into a fresh copy of the global context so that
+// no two items ever share (or see) each other's keys.
+func buildContext(global, item map[string]interface{}) map[string]interface{} {
+	ctxt := make(map[string]interface{}, len(global)+len(item))
+	for k, v := range global {
+		ctxt[k] = v
+	}
+	for k, v := range item {
+		ctxt[k] = v
+	}
+	return ctxt
+}
+
+// renderItem builds the output image for a single task, writing its progress
+// to the specified logger.  If the output is a single pdf, or is imposed, the
+// encoded page and / or image are stored in the result for collation.
+func renderItem(ctx context.Context, cfg *Config, t *renderTask, lg *log.Logger, r *renderResult) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	output := t.output
+	if t.index == 0 {
+		lg.Printf("Processing job with prefix: %s (%s)\n", output.Prefix, output.Background)
+	}
+	lg.Printf("  Processing item #%d\n", t.index+1)
+
+	// Build the context for each metadata item.
+	ctxt := buildContext(cfg.Context, t.item)
+
+	offmt := cfg.OutputFormat
+	ofdpi := float64(cfg.OutputDpi)
+	ofpath, err := itemPath(cfg, output, t.index, t.item)
+	if err != nil {
+		return err
+	}
+
+	// Items that are collated are always rendered natively, and only written
+	// to their own file if the imposition asks for it.
+	imposed := output.Imposition != nil
+	writeFile := output.writesItems()
+	collated := output.SinglePDF || imposed
+
+	// Files that are unchanged since the last run are kept when resuming, and
+	// existing files are only replaced as the overwrite policy allows.  Items
+	// that are also collated are still rendered for their documents.
+	if r.inputHash, err = cfg.manifest.inputHash(cfg, output, ctxt); err != nil {
+		return fmt.Errorf("unable to hash inputs: %s", err.Error())
+	}
+	r.inputTime = cfg.inputTime(output, ctxt)
+	if writeFile && cfg.opts.Resume && cfg.manifest.unchanged(ofpath, r.inputHash) {
+		lg.Printf("  --> Unchanged output file: %s\n", ofpath)
+		if !collated {
+			return nil
+		}
+		writeFile = false
+	}
+	if writeFile {
+		ok, err := cfg.shouldWrite(ofpath, r.inputTime)
+		if err != nil {
+			return err
+		}
+		if !ok {
+			lg.Printf("  --> Skipped existing output file: %s\n", ofpath)
+			if !collated {
+				return nil
+			}
+			writeFile = false
+		}
+	}
+
+	// Pages of pdf documents that are kept as they are are not rendered, and
+	// neither are items that are only added to them.
+	page := output.SinglePDF && !cfg.kept[pdfPath(cfg, output)]
+	sheet := imposed && !(sheetFormat(cfg, output) == "pdf" && cfg.kept[sheetPath(cfg, output, 0)])
+	if !writeFile && !page && !sheet {
+		return nil
+	}
+	if writeFile {
+		if err := os.MkdirAll(path.Dir(ofpath), 0777); err != nil {
+			return fmt.Errorf("unable to create output directory: %s", err.Error())
+		}
+	}
+
+	// Build the set of renderables to build the ouput image.
+	renderables, err := cfg.renderables(output, ctxt, lg)
+	if err != nil {
+		return err
+	}
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	// Generate the output image data.
+	if collated {
+		bg, err := composite.LoadBackground(output.Background, output.Filters)
+		if err != nil {
+			return fmt.Errorf("unable to load background: %s", err.Error())
+		}
+		img, err := composite.RenderOnto(bg, renderables)
+		if err != nil {
+			return fmt.Errorf("unable to build image: %s", err.Error())
+		}
+		if page {
+			if r.page, err = pdf.NewPage(img, ofdpi); err != nil {
+				return fmt.Errorf("unable to build pdf page: %s", err.Error())
+			}
+		}
+		if sheet {
+			r.img = img
+		}
+		if writeFile {
+			if err := composite.WriteImage(cfg.printImage(img, offmt), ofpath, offmt, ofdpi); err != nil {
+				return fmt.Errorf("unable to write image: %s", err.Error())
+			}
+		}
+	} else {
+		job := &composite.Job{
+			Background: output.Background,
+			Filters:    output.Filters,
+			Items:      renderables,
+			Path:       ofpath,
+			Format:     offmt,
+			ColorSpace: cfg.ColorSpace,
+			Dpi:        ofdpi,
+			CMYK:       cfg.cmyk,
+		}
+		if err := cfg.backend.Build(job); err != nil {
+			return fmt.Errorf("unable to build image: %s", err.Error())
+		}
+	}
+
+	if cfg.opts.Verbose {
+		for idx, renderable := range renderables {
+			if r, ok := renderable.(composite.Reporter); ok && len(r.Report()) > 0 {
+				lg.Printf("    * overlay %d: %s\n", idx+1, r.Report())
+			}
+		}
+	}
+
+	if writeFile {
+		entry := &manifestEntry{Path: ofpath, Output: output.Prefix, Item: t.index + 1, InputHash: r.inputHash}
+		if err := cfg.manifest.record(entry); err != nil {
+			return fmt.Errorf("unable to record output file: %s", err.Error())
+		}
+		lg.Printf("  --> Generated output file: %s\n", ofpath)
+	}
+	return nil
+}
+
+// renderables builds the renderables for the overlays of the output, logging
+// each to `lg`.
+func (c *Config) renderables(output *Output, ctxt map[string]interface{}, lg *log.Logger) ([]composite.Renderable, error) {
+	renderables := []composite.Renderable{}
+	for idx, overlay := range output.Overlays {
+		lg.Printf("    * adding %s overlay at index %d\n", overlay.Type, idx+1)
+
+		renderable, err := overlay.GetRenderable(ctxt, c)
+		if err != nil {
+			return nil, fmt.Errorf("unable to get renderable for overlay %d: %s", idx+1, err.Error())
+		}
+		renderables = append(renderables, renderable)
+	}
+	return renderables, nil
+}
+
+////////////////////////////////////////////////////////////////////////////////
+
+// itemPath returns the path of the file written for the item at `index` of the
+// output.  If the output has a `filename` template, it is evaluated against
+// the item's context and sanitized, and the extension of the output format is
+// added unless it is already present.
+func itemPath(cfg *Config, output *Output, index int, item map[string]interface{}) (string, error) {
+	offmt := cfg.OutputFormat
+	if len(output.Filename) == 0 {
+		return path.Join(cfg.opts.OutDir, fmt.Sprintf("%04d_%s.%s", index, output.Prefix, offmt)), nil
+	}
+
+	var buf bytes.Buffer
+	t, err := parseTemplate(output.Filename)
+	if err != nil {
+		return "", fmt.Errorf("filename: %s", err.Error())
+	}
+	if err := t.Execute(&buf, buildContext(cfg.Context, item)); err != nil {
+		return "", fmt.Errorf("filename: unable to execute template: %s", err.Error())
+	}
+	name := sanitizePath(buf.String())
+	if len(name) == 0 {
+		return "", fmt.Errorf("filename: template %q evaluates to an empty file name", output.Filename)
+	}
+	if !strings.EqualFold(path.Ext(name), "."+offmt) {
+		name += "." + offmt
+	}
+	return path.Join(cfg.opts.OutDir, name), nil
+}
+
+// sanitizePath makes a templated file name safe to use as a path below the
+// output directory.  Both "/" and "\" separate directories, characters that
+// are not valid in file names on some platforms are replaced with "_", and
+// leading and trailing dots and spaces are removed from each part so that the
+// path cannot refer to a parent directory.
+func sanitizePath(name string) string {
+	parts := []string{}
+	for _, part := range strings.FieldsFunc(name, func(r rune) bool { return r == '/' || r == '\\' }) {
+		part = strings.Map(func(r rune) rune {
+			if r < 0x20 || r == 0x7f || strings.ContainsRune(`<>:"|?*`, r) {
+				return '_'
+			}
+			return r
+		}, part)
+		part = strings.Trim(part, " .")
+		if len(part) > 0 {
+			parts = append(parts, part)
+		}
+	}
+	return strings.Join(parts, "/")
+}
+
+// pdfPath returns the path of the pdf that a `single_pdf` output's items are
+// added to.
+func pdfPath(cfg *Config, output *Output) string {
+	return path.Join(cfg.opts.OutDir, fmt.Sprintf("%s.pdf", output.Prefix))
+}
+
+// sheetFormat returns the format of an imposed output's sheets.
+func sheetFormat(cfg *Config, output *Output) string {
+	return strings.ToLower(defaultStringValue(output.Imposition.Format, cfg.OutputFormat))
+}
+
+// sheetPath returns the path of the file that the sheet at `index` of an
+// imposed output is written to.  Pdf sheets are the pages of a single file.
+func sheetPath(cfg *Config, output *Output, index int) string {
+	offmt := sheetFormat(cfg, output)
+	if offmt == "pdf" {
+		return path.Join(cfg.opts.OutDir, fmt.Sprintf("%s_sheets.pdf", output.Prefix))
+	}
+	return path.Join(cfg.opts.OutDir, fmt.Sprintf("sheet_%04d_%s.%s", index, output.Prefix, offmt))
+}
+
+// printImage returns the image to write to a file of the specified format,
+// which is converted to CMYK in the cmyk colorspace if the format allows it.
+// Files that span several items are always written natively.
+func (c *Config) printImage(img image.Image, offmt string) image.Image {
+	return composite.PrintImage(img, offmt, c.ColorSpace, c.cmyk)
+}
+
+////////////////////////////////////////////////////////////////////////////////
+
+// collated tracks the items in a file that is collated from several items,
+// for the manifest and the overwrite policy.
+type collated struct {
+	items  []int // 1 based
+	hashes []string
+	newest time.Time // newest file that any of the items are built from
+}
+
+func (c *collated) add(in *collated) {
+	c.items = append(c.items, in.items...)
+	c.hashes = append(c.hashes, in.hashes...)
+	if in.newest.After(c.newest) {
+		c.newest = in.newest
+	}
+}
+
+func (c *collated) entry(ofpath string, output *Output) *manifestEntry {
+	return &manifestEntry{
+		Path:      ofpath,
+		Output:    output.Prefix,
+		Items:     c.items,
+		InputHash: collatedHash(c.hashes),
+	}
+}
+
+////////////////////////////////////////////////////////////////////////////////
+
+// pdfDoc is a single pdf document that pages are appended to.
+type pdfDoc struct {
+	collated
+	output *Output
+	path   string
+	fd     *composite.AtomicFile
+	w      *pdf.Writer
+}
+
+// collator assembles the files that span multiple items - single pdfs and
+// imposed sheets - from the results of each item, in item order.  Once an item
+// of an output fails, none of the output's remaining files are written.
+type collator struct {
+	cfg     *Config
+	docs    map[string]*pdfDoc
+	paths   []string // document paths, in the order they were created
+	sheets  map[*Output]*impose.Sheet
+	nsheets map[*Output]int
+	inputs  map[*Output]*collated // items on the current sheet
+	failed  map[*Output]bool      // outputs with an item that failed
+}
+
+func newCollator(cfg *Config) *collator {
+	return &collator{
+		cfg:     cfg,
+		docs:    map[string]*pdfDoc{},
+		sheets:  map[*Output]*impose.Sheet{},
+		nsheets: map[*Output]int{},
+		inputs:  map[*Output]*collated{},
+		failed:  map[*Output]bool{},
+	}
+}
+
+// fail discards the files of the output that are still being collated, and
+// ignores any further items of the output, so that existing files are not
+// replaced by ones with items missing.
+func (c *collator) fail(output *Output) {
+	c.failed[output] = true
+	delete(c.sheets, output)
+	delete(c.inputs, output)
+}
+
+// add collates the page and / or image of the result for the task.
+func (c *collator) add(t *renderTask, r *renderResult) error {
+	output := t.output
+	if c.failed[output] {
+		return nil
+	}
+	in := &collated{items: []int{t.index + 1}, hashes: []string{r.inputHash}, newest: r.inputTime}
+	if r.page != nil {
+		if err := c.addPage(output, pdfPath(c.cfg, output), r.page, in); err != nil {
+			return err
+		}
+	}
+
+	if r.img != nil {
+		sheet := c.sheets[output]
+		if sheet == nil {
+			var err error
+			if sheet, err = impose.NewSheet(output.layout, r.img.Bounds().Size()); err != nil {
+				return err
+			}
+			c.sheets[output] = sheet
+		}
+		if err := sheet.Add(r.img); err != nil {
+			return err
+		}
+		if c.inputs[output] == nil {
+			c.inputs[output] = &collated{}
+		}
+		c.inputs[output].add(in)
+		if sheet.Full() {
+			return c.flushSheet(output)
+		}
+	}
+	return nil
+}
+
+// addPage appends the page to the pdf document at `ofpath`, creating the
+// document if this is its first page.  Documents that are kept as they are
+// never get any pages, see `keptDocs`.
+func (c *collator) addPage(output *Output, ofpath string, page *pdf.Page, in *collated) error {
+	doc, ok := c.docs[ofpath]
+	if !ok {
+		var err error
+		doc = &pdfDoc{output: output, path: ofpath}
+		if doc.fd, err = composite.CreateAtomic(doc.path); err != nil {
+			return err
+		}
+		if doc.w, err = pdf.NewWriter(doc.fd); err != nil {
+			doc.fd.Abort()
+			return err
+		}
+		c.docs[ofpath] = doc
+		c.paths = append(c.paths, ofpath)
+	}
+	if err := doc.w.AddPage(page); err != nil {
+		return err
+	}
+	doc.add(in)
+	c.cfg.log.Printf("  --> Added page %d to output file: %s\n", doc.w.Pages(), doc.path)
+	return nil
+}
+
+// flushSheet writes the output's current sheet, either as a page of the
+// output's sheets pdf or as an image file.
+func (c *collator) flushSheet(output *Output) error {
+	sheet := c.sheets[output]
+	if sheet == nil {
+		return nil
+	}
+	in := c.inputs[output]
+	delete(c.sheets, output)
+	delete(c.inputs, output)
+
+	index := c.nsheets[output]
+	c.nsheets[output]++
+
+	dpi := float64(c.cfg.OutputDpi)
+	offmt := sheetFormat(c.cfg, output)
+	ofpath := sheetPath(c.cfg, output, index)
+	if offmt == "pdf" {
+		page, err := pdf.NewPage(sheet.Image(), dpi)
+		if err != nil {
+			return err
+		}
+		return c.addPage(output, ofpath, page, in)
+	}
+
+	if c.cfg.opts.Resume && c.cfg.manifest.unchanged(ofpath, collatedHash(in.hashes)) {
+		c.cfg.log.Printf("  --> Unchanged output file: %s\n", ofpath)
+		return nil
+	}
+	write, err := c.cfg.shouldWrite(ofpath, in.newest)
+	if err != nil {
+		return err
+	}
+	if !write {
+		c.cfg.log.Printf("  --> Skipped existing output file: %s\n", ofpath)
+		return nil
+	}
+	if err := composite.WriteImage(c.cfg.printImage(sheet.Image(), offmt), ofpath, offmt, dpi); err != nil {
+		return err
+	}
+	if err := c.cfg.manifest.record(in.entry(ofpath, output)); err != nil {
+		return err
+	}
+	c.cfg.log.Printf("  --> Generated sheet with %d item(s): %s\n", sheet.Count(), ofpath)
+	return nil
+}
+
+// close writes any partially filled sheets and finishes each pdf document,
+// returning the errors for the files that failed.
+func (c *collator) close() []error {
+	errs := []error{}
+	for _, output := range c.cfg.Outputs {
+		if c.sheets[output] == nil {
+			continue
+		}
+		ofpath := sheetPath(c.cfg, output, c.nsheets[output])
+		if err := c.flushSheet(output); err != nil {
+			c.cfg.log.Printf("  !!! Failed to write sheet for job %s: %s\n", output.Prefix, err.Error())
+			errs = append(errs, &FileError{Output: output.Prefix, Path: ofpath, Err: err})
+		}
+	}
+
+	for _, p := range c.paths {
+		doc := c.docs[p]
+		if c.failed[doc.output] {
+			doc.fd.Abort()
+			c.cfg.log.Printf("  !!! Discarded %s, as some of its items failed\n", doc.path)
+			continue
+		}
+		err := doc.w.Close()
+		if err == nil {
+			err = doc.fd.Commit()
+		} else {
+			doc.fd.Abort()
+		}
+		if err == nil {
+			err = c.cfg.manifest.record(doc.entry(doc.path, doc.output))
+		}
+		if err != nil {
+			c.cfg.log.Printf("  !!! Failed to write %s: %s\n", doc.path, err.Error())
+			errs = append(errs, &FileError{Output: doc.output.Prefix, Path: doc.path, Err: err})
+			continue
+		}
+		c.cfg.log.Printf("  --> Generated output file: %s (%d pages)\n", doc.path, doc.w.Pages())
+	}
+	return errs
+}
+
+// abort discards the pdf documents, leaving any existing files untouched.
+// Partially filled sheets are not written.
+func (c *collator) abort() {
+	for _, p := range c.paths {
+		c.docs[p].fd.Abort()
+	}
+}
+
+////////////////////////////////////////////////////////////////////////////////
+
+// keptDocs returns the paths of the pdf documents - single pdfs and pdf sheets,
+// which span every item of their output - that are kept as they are.  They
+// are decided before any items are rendered, so that their pages are not
+// rendered only to be discarded: when resuming, from the combined input hash
+// of their items, and otherwise from the newest file that any of their items
+// are built from.
+func (c *Config) keptDocs() (map[string]bool, error) {
+	kept := map[string]bool{}
+	if c.opts.Overwrite == OverwriteAlways && !c.opts.Resume {
+		return kept, nil
+	}
+	for _, output := range c.Outputs {
+		paths := []string{}
+		if output.SinglePDF {
+			paths = append(paths, pdfPath(c, output))
+		}
+		if output.Imposition != nil && sheetFormat(c, output) == "pdf" {
+			paths = append(paths, sheetPath(c, output, 0))
+		}
+		if len(paths) == 0 {
+			continue
+		}
+
+		newest := c.modTime
+		hashes := []string{}
+		for _, item := range c.Items {
+			ctxt := buildContext(c.Context, item)
+			if t := c.inputTime(output, ctxt); t.After(newest) {
+				newest = t
+			}
+			if c.opts.Resume {
+				h, err := c.manifest.inputHash(c, output, ctxt)
+				if err != nil {
+					return nil, fmt.Errorf("unable to hash inputs: %s", err.Error())
+				}
+				hashes = append(hashes, h)
+			}
+		}
+		for _, fp := range paths {
+			if c.opts.Resume && c.manifest.unchanged(fp, collatedHash(hashes)) {
+				c.log.Printf("  --> Unchanged output file: %s\n", fp)
+				kept[fp] = true
+				continue
+			}
+			write, err := c.shouldWrite(fp, newest)
+			if err != nil {
+				return nil, err
+			}
+			if !write {
+				c.log.Printf("  --> Skipped existing output file: %s\n", fp)
+				kept[fp] = true
+			}
+		}
+	}
+	return kept, nil
+}
+
+// Run renders every item of every output of the config into the output
+// directory, and records the files in its manifest, which is saved as the
+// batch progresses.  Items are rendered by a pool of workers, and the log of
+// each is written in item order regardless of which worker finishes first.
+// Unless the `KeepGoing` option is set, no new items are started after the
+// first failure.  Cancelling the context stops the batch as soon as the items
+// in progress are done, without writing the files that span several items.
+// Nor are those files written if any of their items fail, so that existing
+// ones are left untouched.  If any items or files fail, the error is a
+// `*BatchError`.
+func Run(ctx context.Context, cfg *Config) error {
+	if err := os.MkdirAll(cfg.opts.OutDir, 0777); err != nil {
+		return fmt.Errorf("unable to create output directory: %s", err.Error())
+	}
+	cfg.modTime = cfg.configTime(cfg.path)
+	var err error
+	if cfg.manifest, err = loadManifest(cfg.manifestPath()); err != nil {
+		return err
+	}
+	if cfg.kept, err = cfg.keptDocs(); err != nil {
+		return err
+	}
+
+	// Build the list of (output, item) pairs that we need to carry out, and
+	// render them across the worker pool.
+	tasks := []*renderTask{}
+	for _, output := range cfg.Outputs {
+		for index, item := range cfg.Items {
+			tasks = append(tasks, &renderTask{
+				seq:    len(tasks),
+				output: output,
+				index:  index,
+				item:   item,
+			})
+		}
+	}
+
+	errs := runTasks(ctx, cfg, tasks)
+	cfg.saveManifest()
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	if len(errs) > 0 {
+		return &BatchError{Total: len(tasks), Errors: errs}
+	}
+	return nil
+}
+
+// saveManifest writes the manifest to the output directory, logging (rather
+// than failing on) any error.
+func (c *Config) saveManifest() {
+	if err := c.manifest.save(c.manifestPath()); err != nil {
+		c.log.Printf("  !!! Failed to write manifest: %s\n", err.Error())
+	}
+}
+
+// runTasks renders the tasks using a pool of workers, and returns the errors
+// of the items and files that failed.
+func runTasks(ctx context.Context, cfg *Config, tasks []*renderTask) []error {
+	jobs := cfg.opts.Jobs
+	if jobs < 1 {
+		jobs = 1
+	}
+
+	queue := make(chan *renderTask)
+	results := make(chan *renderResult)
+
+	// Unless we keep going, the first failure - of an item, or of a file that
+	// it is collated into - stops the dispatch of new tasks as soon as it
+	// happens, even if earlier tasks are still in progress.
+	quit := make(chan struct{})
+	var once sync.Once
+	fail := func() {
+		if !cfg.opts.KeepGoing {
+			once.Do(func() { close(quit) })
+		}
+	}
+
+	// Start the workers.
+	var wg sync.WaitGroup
+	for i := 0; i < jobs; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for t := range queue {
+				r := &renderResult{seq: t.seq}
+				r.err = renderItem(ctx, cfg, t, log.New(&r.logs, "", 0), r)
+				if r.err != nil {
+					fail()
+				}
+				results <- r
+			}
+		}()
+	}
+
+	// Feed the workers until we run out of tasks, or are asked to stop.
+	// Since tasks are dispatched in order, the set of tasks that have been
+	// started is always a contiguous prefix of `tasks`.
+	go func() {
+		defer close(queue)
+		for _, t := range tasks {
+			select {
+			case queue <- t:
+			case <-quit:
+				return
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+
+	go func() {
+		wg.Wait()
+		close(results)
+	}()
+
+	// Emit the results in order as they become available, saving the
+	// manifest every so often.
+	errs := []error{}
+	next := 0
+	pending := map[int]*renderResult{}
+	coll := newCollator(cfg)
+	saved, unsaved := time.Now(), 0
+	for r := range results {
+		pending[r.seq] = r
+		for ; pending[next] != nil; next++ {
+			r := pending[next]
+			delete(pending, next)
+
+			cfg.log.Writer().Write(r.logs.Bytes())
+			if r.err == nil {
+				r.err = coll.add(tasks[r.seq], r)
+			}
+			if r.err != nil {
+				t := tasks[r.seq]
+				cfg.log.Printf("  !!! Failed item #%d for job %s: %s\n", t.index+1, t.output.Prefix, r.err.Error())
+				errs = append(errs, &ItemError{Output: t.output.Prefix, Item: t.index + 1, Err: r.err})
+				coll.fail(t.output)
+				fail()
+			}
+
+			unsaved++
+			if unsaved >= manifestSaveItems || time.Since(saved) >= manifestSaveInterval {
+				cfg.saveManifest()
+				saved, unsaved = time.Now(), 0
+			}
+		}
+	}
+
+	if ctx.Err() != nil {
+		coll.abort()
+		return errs
+	}
+	return append(errs, coll.close()...)
+}
+
+////////////////////////////////////////////////////////////////////////////////
@@ -0,0 +1,239 @@
+package job
+
+////////////////////////////////////////////////////////////////////////////////
+
+import (
+	"bytes"
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+////////////////////////////////////////////////////////////////////////////////
+
+// loadTest writes the config to `dir` and loads it, failing the test if the
+// config has any problems.
+func loadTest(t *testing.T, dir, config string, opts Options) *Config {
+	fp := filepath.Join(dir, "cfg.yaml")
+	config = strings.Replace(config, "$DIR", dir, -1)
+	if err := ioutil.WriteFile(fp, []byte(config), 0644); err != nil {
+		t.Fatal(err)
+	}
+	cfg, ps, err := Load(fp, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, p := range ps.List() {
+		t.Errorf("line %d: %s: %s", p.Line, p.Path, p.Message)
+	}
+	if ps.Len() > 0 {
+		t.FailNow()
+	}
+	return cfg
+}
+
+////////////////////////////////////////////////////////////////////////////////
+
+func TestRunFailedItemKeepsCollatedFiles(t *testing.T) {
+	const config = `output_format: pdf
+items:
+  - img: $DIR/a.png
+  - img: $DIR/b.png
+  - img: $DIR/c.png
+outputs:
+  - prefix: doc
+    background: $DIR/bg.png
+    single_pdf: true
+    overlays:
+      - type: image
+        template: "{{.img}}"
+  - prefix: card
+    background: $DIR/bg.png
+    imposition: {rows: 1, columns: 3, format: png}
+    overlays:
+      - type: image
+        template: "{{.img}}"
+`
+	for _, keepGoing := range []bool{false, true} {
+		dir, done := testDir(t, map[string]string{
+			"bg.png": "40x30",
+			"a.png":  "10x10",
+			"b.png":  "10x10",
+			"c.png":  "10x10",
+		})
+		defer done()
+		out := filepath.Join(dir, "out")
+		opts := Options{OutDir: out, KeepGoing: keepGoing}
+		files := []string{filepath.Join(out, "doc.pdf"), filepath.Join(out, "sheet_0000_card.png")}
+
+		if err := Run(context.Background(), loadTest(t, dir, config, opts)); err != nil {
+			t.Fatal(err)
+		}
+		before := map[string][]byte{}
+		for _, fp := range files {
+			data, err := ioutil.ReadFile(fp)
+			if err != nil {
+				t.Fatal(err)
+			}
+			before[fp] = data
+		}
+
+		// The second item fails to render once its image is corrupted after
+		// the config is validated.
+		cfg := loadTest(t, dir, config, opts)
+		if err := ioutil.WriteFile(filepath.Join(dir, "b.png"), []byte("not a png"), 0644); err != nil {
+			t.Fatal(err)
+		}
+		err := Run(context.Background(), cfg)
+		be, ok := err.(*BatchError)
+		if !ok {
+			t.Fatalf("keep going %v: expected a batch error, got %v", keepGoing, err)
+		}
+		for _, e := range be.Errors {
+			if ie, ok := e.(*ItemError); !ok || ie.Item != 2 {
+				t.Errorf("keep going %v: unexpected error: %s", keepGoing, e.Error())
+			}
+		}
+
+		for _, fp := range files {
+			if data, _ := ioutil.ReadFile(fp); !bytes.Equal(data, before[fp]) {
+				t.Errorf("keep going %v: %s was replaced", keepGoing, fp)
+			}
+		}
+		m, err := loadManifest(filepath.Join(out, manifestName))
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, fp := range files {
+			if e := m.entries[fp]; e == nil || len(e.Items) != 3 {
+				t.Errorf("keep going %v: manifest entry for %s is %+v, expected 3 items", keepGoing, fp, e)
+			}
+		}
+		if tmp, _ := filepath.Glob(filepath.Join(out, ".*")); len(tmp) > 0 {
+			t.Errorf("keep going %v: temporary files are left behind: %v", keepGoing, tmp)
+		}
+	}
+}
+
+func TestRunCollationErrorStopsDispatch(t *testing.T) {
+	dir, done := testDir(t, map[string]string{"bg.png": "40x30"})
+	defer done()
+	out := filepath.Join(dir, "out")
+
+	// The first sheet cannot be written over the directory in its place.
+	if err := os.MkdirAll(filepath.Join(out, "sheet_0000_card.png"), 0777); err != nil {
+		t.Fatal(err)
+	}
+	config := "output_format: png\nitems:\n" + strings.Repeat("  - id: 1\n", 200) + `outputs:
+  - prefix: card
+    background: $DIR/bg.png
+    imposition: {rows: 1, columns: 1}
+`
+	var logs bytes.Buffer
+	cfg := loadTest(t, dir, config, Options{OutDir: out, Jobs: 4, Log: &logs})
+	err := Run(context.Background(), cfg)
+	be, ok := err.(*BatchError)
+	if !ok || len(be.Errors) != 1 {
+		t.Fatalf("expected a batch error for the first sheet, got %v", err)
+	}
+	if n := strings.Count(logs.String(), "Processing item"); n > 20 {
+		t.Errorf("%d items were rendered after the first sheet failed", n-1)
+	}
+}
+
+////////////////////////////////////////////////////////////////////////////////
+
+func TestSanitizePath(t *testing.T) {
+	for _, c := range []struct{ in, expected string }{
+		{"card", "card"},
+		{"a/b/c", "a/b/c"},
+		{`a\b`, "a/b"},
+		{"/abs/card", "abs/card"},
+		{`C:\cards\x`, "C_/cards/x"},
+		{"..", ""},
+		{"../../etc/passwd", "etc/passwd"},
+		{"a/../b", "a/b"},
+		{"a/./b", "a/b"},
+		{"...hidden", "hidden"},
+		{" a / b ", "a/b"},
+		{"a//b", "a/b"},
+		{"what?<is>*this|", "what__is__this_"},
+		{"tab\there", "tab_here"},
+		{"", ""},
+		{" . / .. ", ""},
+	} {
+		if got := sanitizePath(c.in); got != c.expected {
+			t.Errorf("%q: expected %q, got %q", c.in, c.expected, got)
+		}
+	}
+}
+
+func TestItemPath(t *testing.T) {
+	cfg := &Config{OutputFormat: "png"}
+	cfg.opts.OutDir = "out"
+	byName := &Output{Prefix: "card", Filename: "{{.name}}"}
+	for _, c := range []struct {
+		output   *Output
+		name     string
+		expected string
+	}{
+		{&Output{Prefix: "card"}, "x", "out/0003_card.png"},
+		{byName, "x", "out/x.png"},
+		{byName, "x.PNG", "out/x.PNG"},
+		{byName, "x.jpg", "out/x.jpg.png"},
+		{byName, "../x", "out/x.png"},
+		{byName, "/tmp/x", "out/tmp/x.png"},
+		{byName, "a/../../x", "out/a/x.png"},
+		{byName, "", ""},
+		{byName, "..", ""},
+		{byName, " / ", ""},
+	} {
+		fp, err := itemPath(cfg, c.output, 3, map[string]interface{}{"name": c.name})
+		if len(c.expected) == 0 {
+			if err == nil || !strings.Contains(err.Error(), "empty file name") {
+				t.Errorf("%q: expected an empty file name error, got %q, %v", c.name, fp, err)
+			}
+		} else if err != nil || fp != c.expected {
+			t.Errorf("%q: expected %q, got %q, %v", c.name, c.expected, fp, err)
+		}
+	}
+}
+
+// Items whose names only resolve to the same file once they are sanitized are
+// reported when the config is loaded.
+func TestItemPathCollision(t *testing.T) {
+	dir, done := testDir(t, map[string]string{"bg.png": "40x30"})
+	defer done()
+	fp := filepath.Join(dir, "cfg.yaml")
+	err := ioutil.WriteFile(fp, []byte(`output_format: png
+items:
+  - name: x
+  - name: ../x
+  - name: /x.png
+outputs:
+  - prefix: card
+    background: `+filepath.Join(dir, "bg.png")+`
+    filename: "{{.name}}"
+`), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, ps, err := Load(fp, Options{OutDir: filepath.Join(dir, "out")})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	list := ps.List()
+	if len(list) != 1 {
+		t.Fatalf("found %d problems, expected 1", len(list))
+	}
+	expected := filepath.Join(dir, "out", "x.png") + " is also written by item #1 of output card"
+	if p := list[0]; p.Path != "outputs[0].filename" || len(p.Items) != 2 || !strings.Contains(p.Message, expected) {
+		t.Errorf("problem is %s for items %v: %s, expected %s for items [2 3]: %s", p.Path, p.Items, p.Message, "outputs[0].filename", expected)
+	}
+}
+
+////////////////////////////////////////////////////////////////////////////////
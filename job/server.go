@@ -0,0 +1,172 @@
+////////////////////////////////////////////////////////////////////////////////
+
+package job
+
+////////////////////////////////////////////////////////////////////////////////
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/sabhiram/imagenie/composite"
+)
+
+////////////////////////////////////////////////////////////////////////////////
+
+// maxItemBytes limits the size of the json item in a request body.
+const maxItemBytes = 1 << 20
+
+// contentTypes maps each format that can be served to its media type.
+var contentTypes = map[string]string{
+	"png":  "image/png",
+	"jpeg": "image/jpeg",
+	"jpg":  "image/jpeg",
+	"tiff": "image/tiff",
+	"tif":  "image/tiff",
+	"pdf":  "application/pdf",
+}
+
+// server renders items of a loaded config on request.
+type server struct {
+	cfg  *Config
+	caps *composite.Capabilities
+}
+
+// NewHandler returns an http handler that renders items of the config's
+// outputs on request:
+//
+//	GET  /outputs          lists the prefixes of the outputs
+//	GET  /render/<prefix>  renders the item given by the query parameters
+//	POST /render/<prefix>  renders the item given by the json body
+//
+// Query parameters are added to the item of a POST, and are inferred as in
+// csv items.  The `format` parameter selects the format of the image, which
+// defaults to the config's output format.  Items are always rendered natively,
+// and errors are returned as a json object with an "error" key.  The reason
+// an item failed to render is only logged, as it may name files on the host.
+func NewHandler(cfg *Config) (http.Handler, error) {
+	native, err := composite.NewBackend("native", composite.BackendOptions{})
+	if err != nil {
+		return nil, err
+	}
+	s := &server{cfg: cfg, caps: native.Capabilities()}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/outputs", s.outputs)
+	mux.HandleFunc("/render/", s.render)
+	return s.logged(mux), nil
+}
+
+////////////////////////////////////////////////////////////////////////////////
+
+func (s *server) outputs(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		writeError(w, http.StatusMethodNotAllowed, "%s is not allowed", r.Method)
+		return
+	}
+	prefixes := []string{}
+	for _, output := range s.cfg.Outputs {
+		prefixes = append(prefixes, output.Prefix)
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{"outputs": prefixes})
+}
+
+func (s *server) render(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet && r.Method != http.MethodPost {
+		writeError(w, http.StatusMethodNotAllowed, "%s is not allowed", r.Method)
+		return
+	}
+
+	prefix := strings.TrimPrefix(r.URL.Path, "/render/")
+	output := s.cfg.Output(prefix)
+	if output == nil {
+		writeError(w, http.StatusNotFound, "%s is not a valid output", prefix)
+		return
+	}
+
+	query := r.URL.Query()
+	offmt := strings.ToLower(defaultStringValue(query.Get("format"), s.cfg.OutputFormat))
+	contentType, ok := contentTypes[offmt]
+	if !ok || !s.caps.HasFormat(offmt) {
+		writeError(w, http.StatusBadRequest, "%s is not a valid format", offmt)
+		return
+	}
+
+	item, err := requestItem(w, r)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, "invalid item: %s", err.Error())
+		return
+	}
+
+	var buf bytes.Buffer
+	if err := EncodeAs(r.Context(), s.cfg, output, item, offmt, &buf); err != nil {
+		s.cfg.log.Printf("  !!! Failed to render item for job %s: %s\n", prefix, err.Error())
+		writeError(w, http.StatusUnprocessableEntity, "unable to render the item for %s", prefix)
+		return
+	}
+	w.Header().Set("Content-Type", contentType)
+	w.Header().Set("Content-Length", fmt.Sprintf("%d", buf.Len()))
+	w.Write(buf.Bytes())
+}
+
+// requestItem returns the item of the request: the json object in the body of
+// a POST, with the query parameters (other than `format`) added to it.
+func requestItem(w http.ResponseWriter, r *http.Request) (map[string]interface{}, error) {
+	item := map[string]interface{}{}
+	if r.Method == http.MethodPost {
+		dec := json.NewDecoder(http.MaxBytesReader(w, r.Body, maxItemBytes))
+		dec.UseNumber()
+		if err := dec.Decode(&item); err != nil && err != io.EOF {
+			return nil, err
+		}
+		if item == nil {
+			return nil, fmt.Errorf("expected a json object")
+		}
+		normalizeNumbers(item)
+	}
+	for key, values := range r.URL.Query() {
+		if key == "format" || len(values) == 0 {
+			continue
+		}
+		item[key] = inferValue(values[len(values)-1])
+	}
+	return item, nil
+}
+
+// writeError writes a json error response with the status code.
+func writeError(w http.ResponseWriter, code int, format string, args ...interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	json.NewEncoder(w).Encode(map[string]string{"error": fmt.Sprintf(format, args...)})
+}
+
+////////////////////////////////////////////////////////////////////////////////
+
+// statusWriter records the status code of a response for the logs.
+type statusWriter struct {
+	http.ResponseWriter
+	code int
+}
+
+func (w *statusWriter) WriteHeader(code int) {
+	w.code = code
+	w.ResponseWriter.WriteHeader(code)
+}
+
+// logged logs a line for each request once it has been handled.
+func (s *server) logged(h http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+		sw := &statusWriter{ResponseWriter: w, code: http.StatusOK}
+		h.ServeHTTP(sw, r)
+		s.cfg.log.Printf("%s %s %d (%s)\n", r.Method, r.URL.RequestURI(), sw.code, time.Since(start).Round(time.Millisecond))
+	})
+}
+
+////////////////////////////////////////////////////////////////////////////////
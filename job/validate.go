@@ -0,0 +1,622 @@
+////////////////////////////////////////////////////////////////////////////////
+
+package job
+
+////////////////////////////////////////////////////////////////////////////////
+
+import (
+	"bytes"
+	"fmt"
+	goimage "image"
+	"io"
+	"io/ioutil"
+	"log"
+	"os"
+	"sort"
+	"strings"
+	"text/template"
+
+	"gopkg.in/yaml.v2"
+
+	"github.com/sabhiram/imagenie/composite"
+	"github.com/sabhiram/imagenie/composite/cmyk"
+	"github.com/sabhiram/imagenie/composite/filter"
+	"github.com/sabhiram/imagenie/composite/qr"
+)
+
+////////////////////////////////////////////////////////////////////////////////
+
+// Problem is a single issue found in the config.  The `Path` identifies the
+// offending key (ex: "outputs[0].overlays[1].foreground") and is used to look
+// up its `Line` in the config file.
+type Problem struct {
+	Path    string `json:"path,omitempty"`
+	Line    int    `json:"line,omitempty"`
+	Message string `json:"message"`
+	Items   []int  `json:"items,omitempty"` // (1 based) items that the problem applies to, if any
+}
+
+// Problems collects all of the issues found in a config file, so that they can
+// be reported together rather than one at a time.
+type Problems struct {
+	lines lineIndex
+	list  []*Problem
+	byKey map[string]*Problem // per item problems, merged across items
+}
+
+func newProblems(raw []byte) *Problems {
+	return &Problems{
+		lines: indexLines(raw),
+		byKey: map[string]*Problem{},
+	}
+}
+
+// add records a problem with the config key at `path`.
+func (ps *Problems) add(path string, format string, args ...interface{}) {
+	ps.list = append(ps.list, &Problem{
+		Path:    path,
+		Line:    ps.lines.line(path),
+		Message: fmt.Sprintf(format, args...),
+	})
+}
+
+// addItem records a problem that the (zero based) item `index` has with the
+// config key at `path`.  Identical problems for different items are reported
+// once, along with the list of the items affected.
+func (ps *Problems) addItem(path string, index int, format string, args ...interface{}) {
+	msg := fmt.Sprintf(format, args...)
+	key := path + "\x00" + msg
+	if p, ok := ps.byKey[key]; ok {
+		p.Items = append(p.Items, index+1)
+		return
+	}
+	ps.add(path, "%s", msg)
+	p := ps.list[len(ps.list)-1]
+	p.Items = []int{index + 1}
+	ps.byKey[key] = p
+}
+
+// addYAML records the errors returned by the yaml decoder, which carry their
+// own line numbers.
+func (ps *Problems) addYAML(err error) {
+	msgs := []string{err.Error()}
+	if te, ok := err.(*yaml.TypeError); ok {
+		msgs = te.Errors
+	}
+	for _, msg := range msgs {
+		p := &Problem{Message: strings.TrimPrefix(msg, "yaml: ")}
+		var line int
+		if n, _ := fmt.Sscanf(p.Message, "line %d:", &line); n == 1 {
+			p.Line = line
+			p.Message = strings.TrimSpace(p.Message[strings.Index(p.Message, ":")+1:])
+		}
+		ps.list = append(ps.list, p)
+	}
+}
+
+// Len returns the number of problems found.
+func (ps *Problems) Len() int {
+	return len(ps.list)
+}
+
+// List returns the problems, in the order they appear in the config file.
+func (ps *Problems) List() []*Problem {
+	sort.SliceStable(ps.list, func(i, j int) bool {
+		return ps.list[i].Line < ps.list[j].Line
+	})
+	return ps.list
+}
+
+// Error summarizes the problems, so that they can be returned as an error.
+func (ps *Problems) Error() string {
+	return fmt.Sprintf("%d problem(s) found in the config", ps.Len())
+}
+
+// Print writes each problem, in the order they appear in the config file, as
+// "<file>:<line>: <path>: <message>".
+func (ps *Problems) Print(w io.Writer, file string) {
+	for _, p := range ps.List() {
+		loc := file
+		if p.Line > 0 {
+			loc = fmt.Sprintf("%s:%d", file, p.Line)
+		}
+		if len(p.Path) > 0 {
+			loc += ": " + p.Path
+		}
+		fmt.Fprintf(w, "%s: %s%s\n", loc, itemList(p.Items), p.Message)
+	}
+}
+
+// itemList formats the list of items affected by a problem, eliding all but
+// the first few.
+func itemList(items []int) string {
+	const max = 5
+	if len(items) == 0 {
+		return ""
+	}
+	strs := []string{}
+	for i, item := range items {
+		if i == max {
+			break
+		}
+		strs = append(strs, fmt.Sprintf("#%d", item))
+	}
+	s := "item " + strings.Join(strs, ", ")
+	if len(items) > 1 {
+		s = "items " + strings.Join(strs, ", ")
+	}
+	if len(items) > max {
+		s += fmt.Sprintf(" (and %d more)", len(items)-max)
+	}
+	return s + ": "
+}
+
+////////////////////////////////////////////////////////////////////////////////
+
+// lineIndex maps the path of each key in a yaml document to its line number.
+type lineIndex map[string]int
+
+// indexLines builds a line index of the block style yaml mappings and
+// sequences in `raw`.  Flow style collections and block scalars are not
+// descended into, their keys resolve to the line of the enclosing key.
+func indexLines(raw []byte) lineIndex {
+	type level struct {
+		indent int
+		path   string
+		seq    bool // a sequence, `idx` is the current entry
+		idx    int
+		entry  bool // the mapping of a sequence entry
+	}
+	ix := lineIndex{}
+	stack := []*level{{indent: -1}}
+	top := func() *level { return stack[len(stack)-1] }
+	pop := func(keep func(l *level) bool) {
+		for len(stack) > 1 && !keep(top()) {
+			stack = stack[:len(stack)-1]
+		}
+	}
+
+	scalar := -1 // indent of the key that owns a block scalar being skipped
+	for n, ln := range strings.Split(string(raw), "\n") {
+		content := strings.TrimLeft(ln, " ")
+		col := len(ln) - len(content)
+		if len(strings.TrimSpace(content)) == 0 || content[0] == '#' {
+			continue
+		}
+		if scalar >= 0 {
+			if col > scalar {
+				continue
+			}
+			scalar = -1
+		}
+
+		// Sequence entries, which may start a mapping on the same line.
+		for strings.HasPrefix(content, "-") && (len(content) == 1 || content[1] == ' ') {
+			pop(func(l *level) bool { return l.indent <= col })
+			if l := top(); l.seq && l.indent == col {
+				l.idx++
+			} else {
+				stack = append(stack, &level{indent: col, path: l.path, seq: true})
+			}
+			seq := top()
+			path := fmt.Sprintf("%s[%d]", seq.path, seq.idx)
+			ix[path] = n + 1
+
+			rest := strings.TrimLeft(content[1:], " ")
+			col += len(content) - len(rest)
+			content = rest
+			stack = append(stack, &level{indent: col, path: path, entry: true})
+			if len(content) == 0 {
+				break
+			}
+		}
+		if len(content) == 0 {
+			continue
+		}
+
+		key, value, ok := splitKey(content)
+		if !ok {
+			continue
+		}
+		pop(func(l *level) bool { return l.indent < col || (l.entry && l.indent == col) })
+		path := key
+		if parent := top().path; len(parent) > 0 {
+			path = parent + "." + key
+		}
+		ix[path] = n + 1
+		stack = append(stack, &level{indent: col, path: path})
+		if strings.HasPrefix(value, "|") || strings.HasPrefix(value, ">") {
+			scalar = col
+		}
+	}
+	return ix
+}
+
+// splitKey splits a "key: value" line.  Lines that do not start with a plain
+// mapping key are not split.
+func splitKey(s string) (string, string, bool) {
+	if len(s) == 0 {
+		return "", "", false
+	}
+	if strings.ContainsAny(s[:1], `"'[{&*!|>%@`+"`") {
+		return "", "", false
+	}
+	i := strings.Index(s, ": ")
+	if i < 0 {
+		if !strings.HasSuffix(s, ":") {
+			return "", "", false
+		}
+		i = len(s) - 1
+	}
+	value := strings.TrimSpace(s[i+1:])
+	if strings.HasPrefix(value, "#") {
+		value = ""
+	}
+	return strings.TrimSpace(s[:i]), value, true
+}
+
+// line returns the line of the key at `path`, or that of its closest parent
+// if the key itself is not in the file.
+func (ix lineIndex) line(path string) int {
+	for len(path) > 0 {
+		if n, ok := ix[path]; ok {
+			return n
+		}
+		i := strings.LastIndexAny(path, ".[")
+		if i < 0 {
+			break
+		}
+		path = path[:i]
+	}
+	return 0
+}
+
+////////////////////////////////////////////////////////////////////////////////
+
+// Load reads and strictly decodes the config file, and prepares it for
+// rendering with the specified options.  All of the problems found in the
+// config are returned, and the config should not be rendered unless there are
+// none.  An error is only returned if the file could not be read, or the
+// options are invalid.
+func Load(fp string, opts Options) (*Config, *Problems, error) {
+	switch opts.Overwrite {
+	case "":
+		opts.Overwrite = OverwriteAlways
+	case OverwriteAlways, OverwriteNever, OverwriteIfNewer:
+	default:
+		return nil, nil, fmt.Errorf("%s is not a valid overwrite policy (always, never, if-newer)", opts.Overwrite)
+	}
+	if opts.Log == nil {
+		opts.Log = ioutil.Discard
+	}
+
+	raw, err := ioutil.ReadFile(fp)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	ps := newProblems(raw)
+	cfg := &Config{path: fp, opts: opts, log: log.New(opts.Log, "", 0)}
+	if err := yaml.UnmarshalStrict(raw, cfg); err != nil {
+		ps.addYAML(err)
+
+		// Type errors still decode the rest of the document, which is worth
+		// checking.  Syntax errors leave nothing to check.
+		if _, ok := err.(*yaml.TypeError); !ok {
+			return cfg, ps, nil
+		}
+	}
+
+	cfg.prepare(ps)
+	cfg.validate(ps)
+	return cfg, ps, nil
+}
+
+// prepare loads the external items and fonts, and fills in the defaults of
+// the config.
+func (c *Config) prepare(ps *Problems) {
+	// Load any items from an external source and combine them with the
+	// inline items.
+	if c.ItemsSource != nil {
+		items, err := c.ItemsSource.Load(c.Context)
+		if err == nil {
+			c.Items, err = c.ItemsSource.Merge(c.Items, items)
+		}
+		if err != nil {
+			ps.add("items_source", "%s", err.Error())
+		}
+	}
+
+	c.loadFonts(ps)
+
+	c.OutputFormat = strings.ToLower(defaultStringValue(c.OutputFormat, "jpeg"))
+	c.OutputDpi = defaultIntValue(c.OutputDpi, 72) // Default: 72 dpi
+	if c.OutputDpi < 0 {
+		ps.add("output_dpi", "output_dpi must be positive")
+	}
+	c.loadBackend(ps)
+	if c.backend != nil && !c.backend.Capabilities().HasFormat(c.OutputFormat) {
+		ps.add("output_format", "%s is not a valid output format for the %s backend", c.OutputFormat, c.backend.Name())
+	}
+
+	c.ColorSpace = strings.ToLower(defaultStringValue(c.ColorSpace, "rgba"))
+	switch c.ColorSpace {
+	case "rgba":
+		if c.CMYK != nil {
+			ps.add("cmyk", "cmyk options require the cmyk colorspace")
+		}
+	case "cmyk":
+		if c.backend != nil && !c.backend.Capabilities().HasColorSpace(c.ColorSpace, c.OutputFormat) {
+			ps.add("colorspace", "the %s backend does not write %s files in the cmyk colorspace", c.backend.Name(), c.OutputFormat)
+		}
+		opts := c.CMYK
+		if opts == nil {
+			opts = &CMYKOptions{}
+		}
+		black := 1.0
+		if opts.Black != nil {
+			black = *opts.Black
+		}
+		conv, err := cmyk.NewConverter(black, opts.InkLimit, opts.EmbedProfile)
+		if err != nil {
+			ps.add("cmyk", "%s", err.Error())
+		}
+		c.cmyk = conv
+	default:
+		ps.add("colorspace", "%s is not a valid colorspace", c.ColorSpace)
+	}
+
+	for i, output := range c.Outputs {
+		p := fmt.Sprintf("outputs[%d]", i)
+		if output.SinglePDF && c.OutputFormat != "pdf" {
+			ps.add(p+".single_pdf", "single_pdf requires the pdf output format")
+		}
+		if output.Imposition != nil {
+			l, err := output.Imposition.Layout(c.OutputDpi)
+			if err != nil {
+				ps.add(p+".imposition", "%s", err.Error())
+			}
+			output.layout = l
+		}
+	}
+}
+
+// loadBackend creates the renderer backend.  The backend option overrides the
+// config, and specifying the path to the imagemagick binaries selects the
+// imagemagick backend unless another one is specified.
+func (c *Config) loadBackend(ps *Problems) {
+	name := defaultStringValue(c.opts.Backend, c.Backend)
+	if len(name) == 0 && len(c.opts.MagickBins) > 0 {
+		name = "imagemagick"
+	}
+	name = defaultStringValue(name, "native")
+
+	b, err := composite.NewBackend(name, composite.BackendOptions{MagickBins: c.opts.MagickBins})
+	if err != nil {
+		ps.add("backend", "%s", err.Error())
+		return
+	}
+	c.Backend = b.Name()
+	c.backend = b
+}
+
+// validate checks the outputs and their overlays against every item.
+func (c *Config) validate(ps *Problems) {
+	if len(c.Outputs) == 0 {
+		ps.add("outputs", "no outputs specified")
+	}
+
+	images := map[string]error{}
+	for i, output := range c.Outputs {
+		p := fmt.Sprintf("outputs[%d]", i)
+		if len(output.Prefix) == 0 {
+			ps.add(p, "prefix must be specified")
+		}
+		if err := checkImage(images, output.Background); err != nil {
+			ps.add(p+".background", "%s", err.Error())
+		} else if err := checkSheet(output); err != nil {
+			ps.add(p+".imposition", "%s", err.Error())
+		}
+		checkFilters(ps, p, output.Filters)
+		for j, overlay := range output.Overlays {
+			overlay.validate(ps, fmt.Sprintf("%s.overlays[%d]", p, j), c, images)
+		}
+	}
+	c.checkFilenames(ps)
+}
+
+// checkFilenames reports items that would be written to the same file as
+// another item, or as the pdf or sheets of an output.  Paths are compared
+// case-insensitively, as not all file systems tell them apart.
+func (c *Config) checkFilenames(ps *Problems) {
+	owners := map[string]string{}
+	claim := func(fp, owner string) string {
+		key := strings.ToLower(fp)
+		if prev, ok := owners[key]; ok {
+			return prev
+		}
+		owners[key] = owner
+		return ""
+	}
+
+	for i, output := range c.Outputs {
+		p := fmt.Sprintf("outputs[%d]", i)
+		if output.SinglePDF {
+			if prev := claim(pdfPath(c, output), "output "+output.Prefix); len(prev) > 0 {
+				ps.add(p+".prefix", "%s is also written by %s", pdfPath(c, output), prev)
+			}
+		}
+		if output.layout != nil && output.layout.PerSheet() > 0 {
+			for sheet := 0; sheet*output.layout.PerSheet() < len(c.Items); sheet++ {
+				fp := sheetPath(c, output, sheet)
+				if prev := claim(fp, "output "+output.Prefix); len(prev) > 0 && prev != "output "+output.Prefix {
+					ps.add(p+".prefix", "%s is also written by %s", fp, prev)
+				}
+			}
+		}
+	}
+
+	for i, output := range c.Outputs {
+		if !output.writesItems() {
+			continue
+		}
+		p := fmt.Sprintf("outputs[%d]", i)
+		if len(output.Filename) > 0 {
+			p += ".filename"
+		}
+		for index, item := range c.Items {
+			fp, err := itemPath(c, output, index, item)
+			if err != nil {
+				ps.addItem(p, index, "%s", err.Error())
+				continue
+			}
+			owner := fmt.Sprintf("item #%d of output %s", index+1, output.Prefix)
+			if prev := claim(fp, owner); len(prev) > 0 {
+				ps.addItem(p, index, "%s is also written by %s", fp, prev)
+			}
+		}
+	}
+}
+
+// validate checks the options of the overlay at `p`, and the result of its
+// template for each item.
+func (o *OverlayOpts) validate(ps *Problems, p string, cfg *Config, images map[string]error) {
+	switch o.Type {
+	case "qr":
+		if _, err := o.qrOptions(); err != nil {
+			ps.add(p, "%s", err.Error())
+		}
+	case "image":
+		if _, err := o.imageLayout(); err != nil {
+			ps.add(p, "%s", err.Error())
+		}
+		checkFilters(ps, p, o.Filters)
+		if _, err := o.Mask.mask(); err != nil {
+			ps.add(p+".mask", "%s", err.Error())
+		} else if o.Mask != nil && len(o.Mask.Image) > 0 {
+			if err := checkImage(images, o.Mask.Image); err != nil {
+				ps.add(p+".mask", "%s", err.Error())
+			}
+		}
+	case "text":
+		if _, err := o.textFont(cfg); err != nil {
+			key := ".fontpath"
+			if len(o.Font) > 0 {
+				key = ".font"
+			}
+			ps.add(p+key, "%s", err.Error())
+		}
+		if _, err := o.textLayout(); err != nil {
+			ps.add(p, "%s", err.Error())
+		}
+	case "":
+		ps.add(p, "overlay type must be specified")
+	default:
+		ps.add(p+".type", "%s is not a valid overlay type (image, qr, text)", o.Type)
+	}
+
+	if _, err := ParseColor(o.FgColor, nil); err != nil {
+		ps.add(p+".foreground", "%s", err.Error())
+	}
+	if _, err := ParseColor(o.BgColor, nil); err != nil {
+		ps.add(p+".background", "%s", err.Error())
+	}
+	if _, err := composite.ParseAnchor(o.Anchor); err != nil {
+		ps.add(p+".anchor", "%s", err.Error())
+	}
+	if mode, err := composite.ParseBlendMode(o.Blend); err != nil {
+		ps.add(p+".blend", "%s", err.Error())
+	} else if cfg.backend != nil && !cfg.backend.Capabilities().HasBlendMode(mode) {
+		ps.add(p+".blend", "the %s backend does not support the %s blend mode", cfg.backend.Name(), mode)
+	}
+	if o.Opacity != nil && (*o.Opacity < 0 || *o.Opacity > 1) {
+		ps.add(p+".opacity", "opacity must be between 0 and 1, got %v", *o.Opacity)
+	}
+
+	t, err := parseTemplate(o.Template)
+	if err != nil {
+		ps.add(p+".template", "%s", err.Error())
+		return
+	}
+	qrOpts, qrErr := o.qrOptions()
+	for index, item := range cfg.Items {
+		var buf bytes.Buffer
+		if err := t.Execute(&buf, buildContext(cfg.Context, item)); err != nil {
+			ps.addItem(p+".template", index, "%s", err.Error())
+			continue
+		}
+		if o.Type == "image" {
+			if err := checkImage(images, buf.String()); err != nil {
+				ps.addItem(p+".template", index, "%s", err.Error())
+			}
+		}
+		if o.Type == "qr" && qrErr == nil {
+			if err := qr.Check(buf.String(), qrOpts, defaultIntValue(o.Size, 12)); err != nil {
+				ps.addItem(p+".template", index, "%s", err.Error())
+			}
+		}
+	}
+}
+
+// checkFilters reports the invalid filters of the output or overlay at `p`.
+func checkFilters(ps *Problems, p string, filters []filter.Filter) {
+	for i, f := range filters {
+		if err := f.Validate(); err != nil {
+			ps.add(fmt.Sprintf("%s.filters[%d]", p, i), "%s", err.Error())
+		}
+	}
+}
+
+// parseTemplate parses an overlay template.  Executing the template fails if it
+// refers to a key that is missing from the item's context.
+func parseTemplate(s string) (*template.Template, error) {
+	t, err := template.New("output").Funcs(funcMap).Option("missingkey=error").Parse(s)
+	if err != nil {
+		return nil, fmt.Errorf("unable to parse template: %s", err.Error())
+	}
+	return t, nil
+}
+
+// checkImage verifies that the file at `fp` is an image that can be decoded.
+// Results are cached in `seen` as most items share the same images.
+func checkImage(seen map[string]error, fp string) error {
+	if err, ok := seen[fp]; ok {
+		return err
+	}
+	err := func() error {
+		if len(fp) == 0 {
+			return fmt.Errorf("image path must be specified")
+		}
+		fd, err := os.Open(fp)
+		if err != nil {
+			return err
+		}
+		defer fd.Close()
+		if _, _, err := goimage.DecodeConfig(fd); err != nil {
+			return fmt.Errorf("unable to decode image %s: %s", fp, err.Error())
+		}
+		return nil
+	}()
+	seen[fp] = err
+	return err
+}
+
+// checkSheet verifies that the items of an imposed output, which are the size
+// of its background, fit on its sheets.
+func checkSheet(output *Output) error {
+	if output.layout == nil {
+		return nil
+	}
+	fd, err := os.Open(output.Background)
+	if err != nil {
+		return err
+	}
+	defer fd.Close()
+	bg, _, err := goimage.DecodeConfig(fd)
+	if err != nil {
+		return err
+	}
+	return output.layout.Validate(goimage.Pt(bg.Width, bg.Height))
+}
+
+////////////////////////////////////////////////////////////////////////////////
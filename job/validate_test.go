@@ -0,0 +1,285 @@
+package job
+
+////////////////////////////////////////////////////////////////////////////////
+
+import (
+	"fmt"
+	"image"
+	"image/png"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"gopkg.in/yaml.v2"
+)
+
+////////////////////////////////////////////////////////////////////////////////
+
+// testDir creates a temporary directory with the files in `files`, which maps
+// names to their contents.  Names ending in ".png" are written as a white
+// image of the size in their contents (ex: "852x480").
+func testDir(t *testing.T, files map[string]string) (string, func()) {
+	dir, err := ioutil.TempDir("", "imagenie-job-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for name, data := range files {
+		fp := filepath.Join(dir, name)
+		if strings.HasSuffix(name, ".png") {
+			var w, h int
+			if _, err := fmt.Sscanf(data, "%dx%d", &w, &h); err != nil {
+				t.Fatal(err)
+			}
+			img := image.NewRGBA(image.Rect(0, 0, w, h))
+			for i := range img.Pix {
+				img.Pix[i] = 0xff
+			}
+			fd, err := os.Create(fp)
+			if err != nil {
+				t.Fatal(err)
+			}
+			err = png.Encode(fd, img)
+			fd.Close()
+			if err != nil {
+				t.Fatal(err)
+			}
+			continue
+		}
+		if err := ioutil.WriteFile(fp, []byte(data), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return dir, func() { os.RemoveAll(dir) }
+}
+
+////////////////////////////////////////////////////////////////////////////////
+
+func TestSplitKey(t *testing.T) {
+	for _, tc := range []struct {
+		line, key, value string
+		ok               bool
+	}{
+		{"", "", "", false},
+		{"key: value", "key", "value", true},
+		{"key:", "key", "", true},
+		{"key:   # comment", "key", "", true},
+		{"key: a: b", "key", "a: b", true},
+		{"url: http://x", "url", "http://x", true},
+		{"plain scalar", "", "", false},
+		{"http://x", "", "", false},
+		{`"quoted": 1`, "", "", false},
+		{"'quoted': 1", "", "", false},
+		{"{a: 1}", "", "", false},
+		{"[a, b]", "", "", false},
+		{"&anchor", "", "", false},
+		{"*alias", "", "", false},
+		{"|", "", "", false},
+	} {
+		key, value, ok := splitKey(tc.line)
+		if key != tc.key || value != tc.value || ok != tc.ok {
+			t.Errorf("splitKey(%q) = %q, %q, %v, expected %q, %q, %v", tc.line, key, value, ok, tc.key, tc.value, tc.ok)
+		}
+	}
+}
+
+func TestIndexLines(t *testing.T) {
+	for _, tc := range []struct {
+		name string
+		yaml string
+		want map[string]int
+	}{
+		{
+			name: "mappings",
+			yaml: "a: 1\nb:\n  c: 2\n\n  # comment\n  d:\n    e: 3\nf: 4\n",
+			want: map[string]int{"a": 1, "b": 2, "b.c": 3, "b.d": 6, "b.d.e": 7, "f": 8},
+		},
+		{
+			name: "sequence of mappings",
+			yaml: "items:\n  - img: x\n    n: 1\n  - img: y\nout: 1\n",
+			want: map[string]int{"items": 1, "items[0]": 2, "items[0].img": 2, "items[0].n": 3, "items[1]": 4, "items[1].img": 4, "out": 5},
+		},
+		{
+			name: "unindented sequence",
+			yaml: "items:\n- img: x\n- img: y\n",
+			want: map[string]int{"items": 1, "items[0]": 2, "items[0].img": 2, "items[1]": 3, "items[1].img": 3},
+		},
+		{
+			name: "bare dashes",
+			yaml: "items:\n  -\n    img: x\n  -\n    img: y\n  -\n",
+			want: map[string]int{"items": 1, "items[0]": 2, "items[0].img": 3, "items[1]": 4, "items[1].img": 5, "items[2]": 6},
+		},
+		{
+			name: "nested sequences",
+			yaml: "a:\n  - - x: 1\n    - y: 2\n  - - z: 3\n",
+			want: map[string]int{
+				"a": 1, "a[0]": 2, "a[0][0]": 2, "a[0][0].x": 2, "a[0][1]": 3, "a[0][1].y": 3,
+				"a[1]": 4, "a[1][0]": 4, "a[1][0].z": 4,
+			},
+		},
+		{
+			name: "flow collections",
+			yaml: "a: {b: 1, c: 2}\nd: [1, 2]\ne:\n  - {f: 1}\n",
+			want: map[string]int{"a": 1, "d": 2, "e": 3, "e[0]": 4},
+		},
+		{
+			name: "quoted keys",
+			yaml: "a:\n  \"b\": 1\n  'c': 2\n  d: 3\n",
+			want: map[string]int{"a": 1, "a.d": 4},
+		},
+		{
+			name: "block scalars",
+			yaml: "a: |\n  b: 1\n  - c\nd: >\n  e: 2\nf: 3\n",
+			want: map[string]int{"a": 1, "d": 4, "f": 6},
+		},
+	} {
+		ix := indexLines([]byte(tc.yaml))
+		if len(ix) != len(tc.want) {
+			t.Errorf("%s: indexed %v, expected %v", tc.name, ix, tc.want)
+			continue
+		}
+		for path, line := range tc.want {
+			if n, ok := ix[path]; !ok || n != line {
+				t.Errorf("%s: %s is on line %d, expected %d", tc.name, path, n, line)
+			}
+		}
+	}
+}
+
+func TestLineIndexParent(t *testing.T) {
+	ix := indexLines([]byte("outputs:\n  - prefix: a\n    overlays:\n      - type: text\n"))
+	for _, tc := range []struct {
+		path string
+		line int
+	}{
+		{"outputs[0].overlays[0].type", 4},
+		{"outputs[0].overlays[0].template", 4},
+		{"outputs[0].overlays[1].type", 3},
+		{"outputs[0].filename", 2},
+		{"outputs[1]", 1},
+		{"items", 0},
+	} {
+		if n := ix.line(tc.path); n != tc.line {
+			t.Errorf("%s is on line %d, expected %d", tc.path, n, tc.line)
+		}
+	}
+}
+
+////////////////////////////////////////////////////////////////////////////////
+
+func TestProblemsYAML(t *testing.T) {
+	raw := []byte("output_format: png\noutputs:\n  - prefix: a\n    background: bg.png\n    bogus: 1\n")
+	ps := newProblems(raw)
+	err := yaml.UnmarshalStrict(raw, &Config{})
+	if err == nil {
+		t.Fatalf("expected an error for the unknown key")
+	}
+	ps.addYAML(err)
+
+	// The decoder reports unknown keys on the line of the mapping they are in.
+	list := ps.List()
+	if len(list) != 1 {
+		t.Fatalf("found %d problems, expected 1", len(list))
+	}
+	if p := list[0]; p.Line != 3 || !strings.Contains(p.Message, "bogus") {
+		t.Errorf("problem is %q on line %d, expected bogus on line 3", p.Message, p.Line)
+	}
+}
+
+func TestCheckFilenames(t *testing.T) {
+	raw := []byte(`output_format: png
+items:
+  - name: a
+  - name: A
+  - name: b
+outputs:
+  - prefix: one
+    filename: "{{.name}}"
+  - prefix: two
+    filename: "{{.missing}}"
+  - prefix: b
+    single_pdf: true
+`)
+	cfg := &Config{}
+	if err := yaml.UnmarshalStrict(raw, cfg); err != nil {
+		t.Fatal(err)
+	}
+	cfg.OutputFormat = "png"
+	cfg.opts.OutDir = "out"
+	ps := newProblems(raw)
+	cfg.checkFilenames(ps)
+
+	list := ps.List()
+	if len(list) != 2 {
+		for _, p := range list {
+			t.Logf("%d: %s: %v%s", p.Line, p.Path, p.Items, p.Message)
+		}
+		t.Fatalf("found %d problems, expected 2", len(list))
+	}
+	for i, tc := range []struct {
+		line  int
+		path  string
+		items []int
+		msg   string
+	}{
+		{8, "outputs[0].filename", []int{2}, "out/A.png is also written by item #1 of output one"},
+		{10, "outputs[1].filename", []int{1, 2, 3}, "map has no entry for key"},
+	} {
+		p := list[i]
+		if p.Line != tc.line || p.Path != tc.path || !strings.Contains(p.Message, tc.msg) {
+			t.Errorf("problem %d is %s (line %d): %s, expected %s (line %d): %s", i, p.Path, p.Line, p.Message, tc.path, tc.line, tc.msg)
+		}
+		if len(p.Items) != len(tc.items) {
+			t.Errorf("problem %d is for items %v, expected %v", i, p.Items, tc.items)
+		}
+	}
+}
+
+func TestValidateImposition(t *testing.T) {
+	dir, done := testDir(t, map[string]string{"bg.png": "852x480"})
+	defer done()
+
+	for _, tc := range []struct {
+		imposition string
+		msg        string
+	}{
+		{"{sheet: a5, rows: 1, columns: 1}", ""},
+		{"{sheet: a5, rows: 4, columns: 4}", "4x4 grid of 852x480 px items does not fit"},
+		{"{sheet: a5, columns: 2}", "rows and columns must be positive"},
+	} {
+		fp := filepath.Join(dir, "cfg.yaml")
+		err := ioutil.WriteFile(fp, []byte(`output_format: png
+output_dpi: 300
+items:
+  - id: 1
+outputs:
+  - prefix: card
+    background: `+filepath.Join(dir, "bg.png")+`
+    imposition: `+tc.imposition+`
+`), 0644)
+		if err != nil {
+			t.Fatal(err)
+		}
+		_, ps, err := Load(fp, Options{OutDir: dir})
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		list := ps.List()
+		if len(tc.msg) == 0 {
+			if len(list) > 0 {
+				t.Errorf("%s: unexpected problem: %s", tc.imposition, list[0].Message)
+			}
+			continue
+		}
+		if len(list) != 1 {
+			t.Fatalf("%s: found %d problems, expected 1", tc.imposition, len(list))
+		}
+		if p := list[0]; p.Line != 8 || p.Path != "outputs[0].imposition" || !strings.Contains(p.Message, tc.msg) {
+			t.Errorf("%s: problem is %s (line %d): %s, expected %s", tc.imposition, p.Path, p.Line, p.Message, tc.msg)
+		}
+	}
+}
+
+////////////////////////////////////////////////////////////////////////////////
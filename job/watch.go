@@ -0,0 +1,284 @@
+////////////////////////////////////////////////////////////////////////////////
+
+package job
+
+////////////////////////////////////////////////////////////////////////////////
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"html/template"
+	"io"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/sabhiram/imagenie/composite"
+)
+
+////////////////////////////////////////////////////////////////////////////////
+
+// watchInterval is how often the watched files are checked for changes.
+var watchInterval = 500 * time.Millisecond
+
+// Preview is the preview item rendered for an output.
+type Preview struct {
+	Output string // prefix of the output
+	Path   string // png file the preview was written to
+	Image  []byte // png encoded preview, if it rendered
+	Err    error
+}
+
+// Previews holds the latest previews rendered by `Watch`.  It is an http
+// handler that serves them on a page which reloads itself whenever they are
+// rendered again.
+type Previews struct {
+	mu       sync.Mutex
+	version  int
+	problems string // problems with the config, if it could not be rendered
+	previews []*Preview
+}
+
+func (p *Previews) update(problems string, previews []*Preview) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.version++
+	p.problems = problems
+	p.previews = previews
+}
+
+////////////////////////////////////////////////////////////////////////////////
+
+// Watch loads the config at `fp`, and renders the item at `index` (0 based)
+// for each of its outputs to a png in the output directory.  The config, its
+// items source and fonts, and the backgrounds and images used by the item are
+// polled for changes, and the previews are rendered again (reloading the
+// config) whenever any of them change.  Problems with the config are logged,
+// and watching continues until they are fixed.  Previews are always rendered
+// natively, and are also stored in `previews` if it is not nil.  Watch
+// returns once the context is cancelled.
+func Watch(ctx context.Context, fp string, opts Options, index int, previews *Previews) error {
+	if previews == nil {
+		previews = &Previews{}
+	}
+	if opts.Log == nil {
+		opts.Log = ioutil.Discard
+	}
+	lg := log.New(opts.Log, "", 0)
+	if err := os.MkdirAll(opts.OutDir, 0777); err != nil {
+		return fmt.Errorf("unable to create output directory: %s", err.Error())
+	}
+
+	for {
+		files := renderPreviews(ctx, fp, opts, index, lg, previews)
+		lg.Printf("Watching %d file(s) for changes\n", len(files))
+		if err := waitForChange(ctx, files); err != nil {
+			return err
+		}
+	}
+}
+
+// renderPreviews loads the config and renders the previews, and returns the
+// files that they were built from.
+func renderPreviews(ctx context.Context, fp string, opts Options, index int, lg *log.Logger, previews *Previews) []string {
+	cfg, ps, err := Load(fp, opts)
+	if err != nil {
+		lg.Printf("  !!! %s\n", err.Error())
+		previews.update(err.Error(), nil)
+		return []string{fp}
+	}
+	files := cfg.watchFiles(index)
+	if ps.Len() > 0 {
+		var buf bytes.Buffer
+		ps.Print(&buf, fp)
+		lg.Print(buf.String())
+		previews.update(buf.String(), nil)
+		return files
+	}
+	if index < 0 || index >= len(cfg.Items) {
+		msg := fmt.Sprintf("item %d is out of range, %s has %d item(s)", index, fp, len(cfg.Items))
+		lg.Printf("  !!! %s\n", msg)
+		previews.update(msg, nil)
+		return files
+	}
+
+	rendered := []*Preview{}
+	for _, output := range cfg.Outputs {
+		p := &Preview{
+			Output: output.Prefix,
+			Path:   filepath.Join(opts.OutDir, fmt.Sprintf("preview_%s.png", sanitizePath(output.Prefix))),
+		}
+		var buf bytes.Buffer
+		if p.Err = EncodeAs(ctx, cfg, output, cfg.Items[index], "png", &buf); p.Err == nil {
+			p.Image = buf.Bytes()
+			p.Err = writePreview(p.Path, p.Image)
+		}
+		if p.Err != nil {
+			lg.Printf("  !!! Preview of %s failed: %s\n", output.Prefix, p.Err.Error())
+		} else {
+			lg.Printf("  Preview of %s written to %s\n", output.Prefix, p.Path)
+		}
+		rendered = append(rendered, p)
+	}
+	previews.update("", rendered)
+	return files
+}
+
+// writePreview replaces the file at `fp` with the png.
+func writePreview(fp string, png []byte) error {
+	fd, err := composite.CreateAtomic(fp)
+	if err != nil {
+		return err
+	}
+	if _, err := fd.Write(png); err != nil {
+		fd.Abort()
+		return err
+	}
+	return fd.Commit()
+}
+
+// watchFiles returns the files that the previews of the item at `index` are
+// built from.
+func (c *Config) watchFiles(index int) []string {
+	files := []string{c.path}
+	if len(c.FontPath) > 0 {
+		files = append(files, c.FontPath)
+	}
+	if c.ItemsSource != nil {
+		files = append(files, c.ItemsSource.Path)
+	}
+	for _, font := range c.Fonts {
+		files = append(files, font)
+	}
+	if index >= 0 && index < len(c.Items) {
+		ctxt := buildContext(c.Context, c.Items[index])
+		for _, output := range c.Outputs {
+			// Configs with problems may have outputs that failed to decode.
+			if output != nil {
+				files = append(files, c.inputFiles(output, ctxt)...)
+			}
+		}
+	}
+	return files
+}
+
+////////////////////////////////////////////////////////////////////////////////
+
+// fileState is what is compared to detect that a file changed.  Files that do
+// not exist have the zero state.
+type fileState struct {
+	modTime time.Time
+	size    int64
+}
+
+func statFiles(files []string) map[string]fileState {
+	states := map[string]fileState{}
+	for _, fp := range files {
+		if fi, err := os.Stat(fp); err == nil {
+			states[fp] = fileState{fi.ModTime(), fi.Size()}
+		} else {
+			states[fp] = fileState{}
+		}
+	}
+	return states
+}
+
+// waitForChange polls the files until any of them change, or the context is
+// cancelled.  Once a change is seen, it waits for another interval so that
+// an editor that writes a file in several steps is done with it.
+func waitForChange(ctx context.Context, files []string) error {
+	states := statFiles(files)
+	ticker := time.NewTicker(watchInterval)
+	defer ticker.Stop()
+
+	changed := false
+	for {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-ticker.C:
+		}
+		if changed {
+			return nil
+		}
+		for fp, state := range statFiles(files) {
+			if state != states[fp] {
+				changed = true
+			}
+		}
+	}
+}
+
+////////////////////////////////////////////////////////////////////////////////
+
+var previewPage = template.Must(template.New("preview").Parse(`<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<title>imagenie preview</title>
+<style>
+body { font-family: sans-serif; background: #eee; }
+img { max-width: 100%; background: #fff; box-shadow: 0 1px 4px #999; }
+pre { color: #b00; white-space: pre-wrap; }
+</style>
+</head>
+<body>
+{{if .Problems}}<pre>{{.Problems}}</pre>{{end}}
+{{range .Previews}}
+<h3>{{.Output}}</h3>
+{{if .Err}}<pre>{{.Err}}</pre>{{else}}<img src="/preview/{{.Output}}.png?v={{$.Version}}">{{end}}
+{{end}}
+<script>
+var version = "{{.Version}}";
+setInterval(function() {
+	fetch("/version").then(function(r) { return r.text(); }).then(function(v) {
+		if (v !== version) { location.reload(); }
+	}).catch(function() {});
+}, 500);
+</script>
+</body>
+</html>
+`))
+
+// ServeHTTP serves the page of previews on "/", each preview on
+// "/preview/<prefix>.png", and the number of times the previews have been
+// rendered on "/version", which the page polls to know when to reload.
+func (p *Previews) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	p.mu.Lock()
+	version, problems, previews := p.version, p.problems, p.previews
+	p.mu.Unlock()
+
+	switch {
+	case r.URL.Path == "/":
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		previewPage.Execute(w, map[string]interface{}{
+			"Version":  version,
+			"Problems": problems,
+			"Previews": previews,
+		})
+	case r.URL.Path == "/version":
+		w.Header().Set("Content-Type", "text/plain")
+		w.Header().Set("Cache-Control", "no-store")
+		fmt.Fprintf(w, "%d", version)
+	case strings.HasPrefix(r.URL.Path, "/preview/"):
+		prefix := strings.TrimSuffix(strings.TrimPrefix(r.URL.Path, "/preview/"), ".png")
+		for _, preview := range previews {
+			if preview.Output == prefix && preview.Image != nil {
+				w.Header().Set("Content-Type", "image/png")
+				io.Copy(w, bytes.NewReader(preview.Image))
+				return
+			}
+		}
+		http.NotFound(w, r)
+	default:
+		http.NotFound(w, r)
+	}
+}
+
+////////////////////////////////////////////////////////////////////////////////